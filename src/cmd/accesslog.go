@@ -0,0 +1,359 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// Operations recorded in the access log.
+const (
+	logCreate         = "create"
+	logAdd            = "add"
+	logGet            = "get"
+	logCopy           = "copy"
+	logEdit           = "edit"
+	logDelete         = "delete"
+	logChangePassword = "change-password"
+	logOTP            = "otp"
+	logShare          = "share"
+	logProtect        = "protect"
+	logUnprotect      = "unprotect"
+	logExpiry         = "expiry"
+	logRun            = "run"
+	logInject         = "inject"
+	logAudit          = "audit"
+	logBreach         = "breach"
+	logSlotAdd        = "slot-add"
+	logSlotRemove     = "slot-remove"
+	logMemberAdd      = "member-add"
+	logMemberRemove   = "member-remove"
+)
+
+// The access log of a keystore is "<keystore>.log" next to it, one JSON line
+// per operation. Each line holds its sequence number, the SHA-256 of the line
+// before it and the record sealed with the keystore's log key, with all of
+// that as associated data. A line that is edited, removed or moved breaks the
+// chain, and the keystore is saved with the head of the log after every
+// record, reads included, so cutting off the end is noticed too.
+type logLine struct {
+	Seq  int
+	Prev string
+	Data string
+}
+
+type logRecord struct {
+	Time       time.Time
+	User       string
+	Host       string
+	Operation  string
+	Identifier string `json:",omitempty"`
+}
+
+// slotLogName names a key slot in the access log.
+func slotLogName(slot models.KeySlot) string {
+	return fmt.Sprintf("slot %d (%s)", slot.ID, slot.Type)
+}
+
+func accessLogPath(keystorePath string) string {
+	return strings.TrimSuffix(keystorePath, ".json") + ".log"
+}
+
+func logAD(keystoreName string, seq int, prev string) []byte {
+	return []byte(fmt.Sprintf("snowpass/log/%d:%s/%d/%s", len(keystoreName), keystoreName, seq, prev))
+}
+
+func hashLogLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// currentUserAndHost names who is running snowpass, for the access log and
+// the record of failed unlocks.
+func currentUserAndHost() (string, string) {
+	name := ""
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, _ := os.Hostname()
+	return name, host
+}
+
+func readLogLines(keystorePath string) ([][]byte, error) {
+	data, err := os.ReadFile(accessLogPath(keystorePath))
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// logRead records a read of ks and saves ks with the new log head. Keystores
+// that have not been written since the access log was introduced have no log
+// key yet and are not logged.
+func logRead(keystorePath string, ks *Keystore, operation, identifier string) {
+	if ks.LogKey == "" || ks.DataKey == nil {
+		return
+	}
+	head, err := appendAccessLog(keystorePath, ks, operation, identifier)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write the access log:", err)
+		return
+	}
+	ks.LogHead = head
+	// the data key is there, so the password is not needed
+	saveKeystore(keystorePath, ks, "")
+}
+
+// logWrite records a change of ks and moves the log head kept in ks, which
+// has to be saved afterwards.
+func logWrite(keystorePath string, ks *Keystore, operation, identifier string) {
+	if ks.LogKey == "" {
+		logKey, err := utils.RandomSecureBuffer(32)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create the access log key:", err)
+			return
+		}
+		ks.LogKey = hex.EncodeToString(logKey.Bytes())
+		logKey.Destroy()
+	}
+	head, err := appendAccessLog(keystorePath, ks, operation, identifier)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write the access log:", err)
+		return
+	}
+	ks.LogHead = head
+}
+
+func appendAccessLog(keystorePath string, ks *Keystore, operation, identifier string) (*models.LogHead, error) {
+	encodedKey := []byte(ks.LogKey)
+	logKey, err := decodeDataKey(encodedKey)
+	utils.Wipe(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	defer logKey.Destroy()
+
+	lines, err := readLogLines(keystorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	next := logLine{Seq: len(lines)}
+	if len(lines) > 0 {
+		next.Prev = hashLogLine(lines[len(lines)-1])
+	}
+
+	record := logRecord{Time: time.Now().UTC(), Operation: operation, Identifier: identifier}
+	record.User, record.Host = currentUserAndHost()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	next.Data, err = sealWithKey(logKey.Bytes(), data, logAD(keystoreName, next.Seq, next.Prev))
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(accessLogPath(keystorePath), os.O_WRONLY|os.O_CREATE|os.O_APPEND, utils.PrivateFileMode)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &models.LogHead{Seq: next.Seq, Hash: hashLogLine(line)}, nil
+}
+
+// resealAccessLog encrypts the records of the access log again with a new
+// log key, so that whoever knew the old key cannot add records. The log has
+// to be intact, and ks saved afterwards.
+func resealAccessLog(keystorePath string, ks *Keystore) error {
+	if ks.LogKey == "" {
+		return nil
+	}
+	records, problems := verifyAccessLog(keystorePath, ks)
+	if len(problems) > 0 {
+		return fmt.Errorf("the access log has been tampered with: %s", strings.Join(problems, ", "))
+	}
+
+	logKey, err := utils.RandomSecureBuffer(32)
+	if err != nil {
+		return err
+	}
+	defer logKey.Destroy()
+
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	var out bytes.Buffer
+	var head *models.LogHead
+	for seq, record := range records {
+		next := logLine{Seq: seq}
+		if head != nil {
+			next.Prev = head.Hash
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		next.Data, err = sealWithKey(logKey.Bytes(), data, logAD(keystoreName, next.Seq, next.Prev))
+		if err != nil {
+			return err
+		}
+		line, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		out.Write(append(line, '\n'))
+		head = &models.LogHead{Seq: seq, Hash: hashLogLine(line)}
+	}
+	if head != nil {
+		if err := utils.WriteFileAtomic(accessLogPath(keystorePath), out.Bytes(), utils.PrivateFileMode); err != nil {
+			return err
+		}
+	}
+
+	ks.LogKey = hex.EncodeToString(logKey.Bytes())
+	ks.LogHead = head
+	return nil
+}
+
+// verifyAccessLog decrypts the access log and checks its chain. It returns
+// the records it could read and a description of every problem found.
+func verifyAccessLog(keystorePath string, ks *Keystore) ([]logRecord, []string) {
+	var problems []string
+	lines, err := readLogLines(keystorePath)
+	if os.IsNotExist(err) {
+		if ks.LogHead != nil {
+			problems = append(problems, "the access log is missing")
+		}
+		return nil, problems
+	}
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	encodedKey := []byte(ks.LogKey)
+	logKey, err := decodeDataKey(encodedKey)
+	utils.Wipe(encodedKey)
+	if err != nil {
+		return nil, []string{"the keystore has no valid access log key"}
+	}
+	defer logKey.Destroy()
+
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	var records []logRecord
+	prev := ""
+	for i, raw := range lines {
+		var line logLine
+		if err := json.Unmarshal(raw, &line); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d is not a log record", i+1))
+			prev = hashLogLine(raw)
+			continue
+		}
+		if line.Seq != i {
+			problems = append(problems, fmt.Sprintf("line %d has sequence number %d, records were removed or reordered", i+1, line.Seq))
+		}
+		if line.Prev != prev {
+			problems = append(problems, fmt.Sprintf("line %d does not follow the line before it", i+1))
+		}
+		prev = hashLogLine(raw)
+
+		data, err := openWithKey(logKey.Bytes(), line.Data, logAD(keystoreName, line.Seq, line.Prev))
+		var record logRecord
+		if err == nil {
+			err = json.Unmarshal(data.Bytes(), &record)
+			data.Destroy()
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d cannot be decrypted, it was altered", i+1))
+			continue
+		}
+		records = append(records, record)
+	}
+
+	if head := ks.LogHead; head != nil {
+		switch {
+		case head.Seq >= len(lines):
+			problems = append(problems, fmt.Sprintf("the log has %d records, but the keystore saw record %d, it was cut short", len(lines), head.Seq+1))
+		case hashLogLine(lines[head.Seq]) != head.Hash:
+			problems = append(problems, fmt.Sprintf("record %d is not the one the keystore saw last", head.Seq))
+		}
+	}
+	return records, problems
+}
+
+// ShowAccessLog prints the access log of a keystore and verifies it. The
+// exit code is ExitFindings when the log was tampered with.
+func ShowAccessLog(dataDir string, args []string) int {
+	if len(args) != 1 {
+		fmt.Println("Usage for log: snowpass log [keystore]")
+		return ExitError
+	}
+	keystoreName, keystorePath, err := KeystorePath(dataDir, args[0])
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if _, err := os.Stat(keystorePath); err != nil {
+		fmt.Printf("Keystore %q does not exist.\n", keystoreName)
+		return ExitError
+	}
+
+	keystoreID := filepath.Base(keystorePath)
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return ExitError
+	}
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return ExitError
+	}
+	storeKeystorePassword(keystoreID, password)
+
+	if ks.LogKey == "" {
+		fmt.Printf("%s has no access log yet, it starts with the next change to the keystore.\n", keystoreName)
+		return ExitClean
+	}
+
+	records, problems := verifyAccessLog(keystorePath, ks)
+	for _, record := range records {
+		fmt.Printf("%s  %-16s %-16s %s\n", record.Time.Local().Format("2006-01-02 15:04:05"), record.User+"@"+record.Host, record.Operation, record.Identifier)
+	}
+
+	if len(problems) > 0 {
+		color.Red("The access log of %s has been tampered with:", keystoreName)
+		for _, problem := range problems {
+			fmt.Println("   ", problem)
+		}
+		return ExitFindings
+	}
+	color.Green("%d records, the chain is intact.", len(records))
+	return ExitClean
+}
@@ -1,12 +1,15 @@
 package cmd
 
 import (
-	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,10 +18,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/atotto/clipboard"
 	"github.com/fatih/color"
 	"golang.org/x/crypto/scrypt"
-	"golang.org/x/term"
 
 	"github.com/fluffysnowman/snowpass/models"
 	"github.com/fluffysnowman/snowpass/states"
@@ -39,6 +40,16 @@ func getCurrentKeystoreID() string {
 	return currentKeystoreID
 }
 
+func keystoreNameFromID(keystoreID string) string {
+	return strings.TrimSuffix(keystoreID, ".json")
+}
+
+// currentConfig returns the config with the overrides for the keystore that
+// is currently being worked on applied.
+func currentConfig() models.Config {
+	return states.GlobalConfig.ForKeystore(keystoreNameFromID(getCurrentKeystoreID()))
+}
+
 var bypassSessionCheck bool
 
 func setBypassSessionCheck(bypass bool) {
@@ -47,83 +58,117 @@ func setBypassSessionCheck(bypass bool) {
 
 func promptForPassword(verify bool, keystoreID string) (string, error) {
 	setCurrentKeystoreID(keystoreID)
+	freshPassword = false
 
 	if !bypassSessionCheck {
 		password, err := getKeystorePassword(keystoreID)
 		if err == nil {
 			return password, nil
 		}
+		if hasMemberIdentity(keystoreID) {
+			// loadKeystore unlocks with the identity instead
+			return "", nil
+		}
 	}
 
-	fmt.Print("Enter Master Password: ")
-	bytePassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+	// prompts go to stderr, so that output meant for other programs such
+	// as `audit --json` stays intact
+	fmt.Fprint(os.Stderr, "Enter Master Password: ")
+	password, err := readSecureLine(readSecret)
 	if err != nil {
 		return "", err
 	}
-	password := string(bytePassword)
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
+	freshPassword = true
 
 	if verify {
-		fmt.Print("Verify password: ")
-		byteVerifyPassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprint(os.Stderr, "Verify password: ")
+		verifyPassword, err := readSecureLine(readSecret)
 		if err != nil {
 			return "", err
 		}
-		verifyPassword := string(byteVerifyPassword)
-		fmt.Println()
+		fmt.Fprintln(os.Stderr)
+		match := subtle.ConstantTimeCompare(password.Bytes(), verifyPassword.Bytes()) == 1
+		verifyPassword.Destroy()
 
-		if password != verifyPassword {
+		if !match {
+			password.Destroy()
 			return "", fmt.Errorf("passwords do not match")
 		}
 	}
 
-	if !bypassSessionCheck {
-		storeKeystorePassword(keystoreID, strings.TrimSpace(password))
-	}
-
-	return strings.TrimSpace(password), nil
+	// the password is used until snowpass exits, when DestroySecrets wipes it
+	return password.String(), nil
 }
 
-func promptForData() (string, error) {
-	reader := bufio.NewReader(os.Stdin)
-
+// promptForData reads the value of an entry twice. The caller destroys it.
+func promptForData() (*utils.SecureBuffer, error) {
 	fmt.Print("Enter data: ")
-	data, err := reader.ReadString('\n')
+	data, err := readSecureLine(readLine)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	data = strings.TrimSpace(data)
 
 	fmt.Print("Verify data: ")
-	verifyData, err := reader.ReadString('\n')
+	verifyData, err := readSecureLine(readLine)
 	if err != nil {
-		return "", err
+		data.Destroy()
+		return nil, err
 	}
-	verifyData = strings.TrimSpace(verifyData)
+	match := subtle.ConstantTimeCompare(data.Bytes(), verifyData.Bytes()) == 1
+	verifyData.Destroy()
 
-	if data != verifyData {
-		return "", fmt.Errorf("data entries do not match")
+	if !match {
+		data.Destroy()
+		return nil, fmt.Errorf("data entries do not match")
 	}
 
 	return data, nil
 }
 
-func CreateKeystore(keystorePath string, keystoreName string) {
+// CreateKeystore creates an empty keystore. With a keyfile path the keystore
+// also needs that keyfile to unlock; a random one is written if it does not
+// exist.
+func CreateKeystore(keystorePath string, keystoreName string, keyfilePath string) {
 	if _, err := os.Stat(keystorePath); err == nil {
 		fmt.Println("Keystore already exists.")
 		return
 	}
 
 	keystoreID := filepath.Base(keystorePath)
-	setBypassSessionCheck(true) // Force a new password prompt
-	password, err := promptForPassword(true, keystoreID)
-	setBypassSessionCheck(false)
-	if err != nil {
-		fmt.Println("Failed to read password:", err)
-		return
+	setCurrentKeystoreID(keystoreID)
+
+	password, generated := offerPassphrase()
+	if !generated {
+		var err error
+		setBypassSessionCheck(true) // Force a new password prompt
+		password, err = promptForPassword(true, keystoreID)
+		setBypassSessionCheck(false)
+		if err != nil {
+			fmt.Println("Failed to read password:", err)
+			return
+		}
+		if !checkPasswordStrength(password, currentConfig().Strength.MinScore, "strength.min_score", keystoreName) {
+			return
+		}
 	}
 
 	ks := Keystore{Passwords: make(map[string]string)}
+	if keyfilePath != "" {
+		keyfile, err := newKeyfile(keyfilePath)
+		if err != nil {
+			fmt.Println("Failed to read keyfile:", err)
+			return
+		}
+		ks.Keyfile = keyfile
+	}
+	if err := initKeySlots(&ks, keystoreName, password); err != nil {
+		fmt.Println("Failed to set up key slots:", err)
+		return
+	}
+	offerRecoveryKey(&ks, keystoreName)
+
+	logWrite(keystorePath, &ks, logCreate, "")
 	saveKeystore(keystorePath, &ks, password)
 	createEmptyIndex(keystoreName)
 }
@@ -143,22 +188,50 @@ func AddToKeystore(keystorePath, identifier, keystoreName string) {
 		fmt.Println("Failed to read data:", err)
 		return
 	}
+	defer data.Destroy()
+	if !checkEntryStrength(data.String(), keystoreName, identifier) {
+		return
+	}
+	warnIfBreached(data.String())
+
+	addEntry(keystorePath, identifier, keystoreName, password, data.Bytes())
+}
 
+// addEntry encrypts data under identifier and updates the keystore and its
+// index. It reports whether the entry was stored.
+func addEntry(keystorePath, identifier, keystoreName, password string, data []byte) bool {
 	ks, err := loadKeystore(keystorePath, password)
 	if err != nil {
 		fmt.Println("Failed to load keystore:", err)
-		return
+		return false
 	}
+	return storeEntry(keystorePath, ks, identifier, keystoreName, password, data)
+}
 
-	encryptedData, err := encrypt(data, password)
+// storeEntry is addEntry for a keystore that is already loaded.
+func storeEntry(keystorePath string, ks *Keystore, identifier, keystoreName, password string, data []byte) bool {
+	encryptedData, err := encryptEntry(ks, data, password, keystoreName, identifier)
 	if err != nil {
 		fmt.Println("Failed to encrypt data:", err)
-		return
+		return false
 	}
 
 	ks.Passwords[identifier] = encryptedData
-	saveKeystore(keystorePath, ks, password)
+	touchEntry(ks, identifier)
+	logWrite(keystorePath, ks, logAdd, identifier)
+	if saveKeystore(keystorePath, ks, password) != nil {
+		return false
+	}
 	updateKeystoreIndex(keystoreName, identifier, true)
+	return true
+}
+
+// touchEntry records the time an entry was last written.
+func touchEntry(ks *Keystore, identifier string) {
+	if ks.Modified == nil {
+		ks.Modified = make(map[string]time.Time)
+	}
+	ks.Modified[identifier] = time.Now().UTC()
 }
 
 func GetFromKeystore(keystorePath, identifier string) {
@@ -177,27 +250,76 @@ func GetFromKeystore(keystorePath, identifier string) {
 		return
 	}
 
-	encryptedData, exists := ks.Passwords[identifier]
-	if !exists {
+	if _, exists := ks.Passwords[identifier]; !exists {
 		fmt.Println("Identifier not found.")
 		return
 	}
 
-	data, err := decrypt(encryptedData, password)
+	data, _, err := openEntry(keystorePath, ks, password, identifier)
 	if err != nil {
 		fmt.Println("Failed to decrypt data:", err)
 		return
 	}
+	defer data.Destroy()
 
-	fmt.Println(data)
+	warnIfPastDue(ks, keystoreNameFromID(keystoreID), identifier)
+	os.Stdout.Write(data.Bytes())
+	fmt.Println()
+	logRead(keystorePath, ks, logGet, identifier)
 	storeKeystorePassword(keystoreID, password)
 }
 
-func encrypt(data, password string) (string, error) {
-	key, salt, err := deriveKey(password, nil)
+// Entry ciphertexts are bound to their keystore and identifier through the
+// GCM associated data, so a ciphertext moved under another identifier or into
+// another keystore no longer decrypts. Keystore files are bound to their name
+// the same way. Bound data starts with "ad$"; data written before the binding
+// existed has no prefix and no associated data.
+const boundPrefix = "ad$"
+
+func entryAD(keystoreName, identifier string) []byte {
+	// the lengths keep "a/b" + "c" apart from "a" + "b/c"
+	return []byte(fmt.Sprintf("snowpass/entry/%d:%s/%d:%s", len(keystoreName), keystoreName, len(identifier), identifier))
+}
+
+func keystoreAD(keystoreName string) []byte {
+	return []byte(fmt.Sprintf("snowpass/keystore/%d:%s", len(keystoreName), keystoreName))
+}
+
+// encryptEntry encrypts an entry of ks with its data key, or with the master
+// password for keystores that have no key slots yet.
+func encryptEntry(ks *Keystore, data []byte, password, keystoreName, identifier string) (string, error) {
+	if ks.DataKey != nil {
+		return sealWithKey(ks.DataKey.Bytes(), data, entryAD(keystoreName, identifier))
+	}
+	return encrypt(data, password, nil, entryAD(keystoreName, identifier))
+}
+
+// decryptEntry decrypts an entry of ks. Legacy entries without associated
+// data are still read until the keystore has been upgraded; after that an
+// unbound entry can only have been planted. The caller destroys the value.
+func decryptEntry(ks *Keystore, encryptedData, password, keystoreName, identifier string) (*utils.SecureBuffer, error) {
+	if ks.DataKey != nil {
+		if !strings.HasPrefix(encryptedData, dataKeyPrefix) {
+			return nil, fmt.Errorf("entry is not encrypted with the data key, the keystore may have been tampered with")
+		}
+		return openWithKey(ks.DataKey.Bytes(), encryptedData, entryAD(keystoreName, identifier))
+	}
+	if ks.BoundEntries && !strings.HasPrefix(encryptedData, boundPrefix) {
+		return nil, fmt.Errorf("entry is not bound to its identifier, the keystore may have been tampered with")
+	}
+	return decrypt(encryptedData, password, nil, entryAD(keystoreName, identifier))
+}
+
+// encrypt seals data with a key derived from password and, if not nil, a
+// keyfile digest. The associated data is authenticated but not stored, so
+// decrypt has to be given the same.
+func encrypt(data []byte, password string, keyfile, ad []byte) (string, error) {
+	params := currentConfig().KDF
+	key, salt, err := deriveKey(password, keyfile, nil, params)
 	if err != nil {
 		return "", err
 	}
+	defer utils.Wipe(key)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -214,56 +336,96 @@ func encrypt(data, password string) (string, error) {
 		return "", err
 	}
 
-	encrypted := aesGCM.Seal(nonce, nonce, []byte(data), nil)
-	return fmt.Sprintf("%x:%x", salt, encrypted), nil
+	encrypted := aesGCM.Seal(nonce, nonce, data, ad)
+	prefix := ""
+	if ad != nil {
+		prefix = boundPrefix
+	}
+	return fmt.Sprintf("%sscrypt$%d$%d$%d$%x:%x", prefix, params.ScryptN, params.ScryptR, params.ScryptP, salt, encrypted), nil
+}
+
+// splitKDFParams strips the "scrypt$N$r$p$" prefix written by encrypt. Data
+// written before the prefix existed used the default parameters.
+func splitKDFParams(encryptedData string) (models.KDFConfig, string, error) {
+	params := models.DefaultConfig().KDF
+	if !strings.HasPrefix(encryptedData, "scrypt$") {
+		return params, encryptedData, nil
+	}
+
+	fields := strings.SplitN(encryptedData, "$", 5)
+	if len(fields) != 5 {
+		return params, "", fmt.Errorf("invalid encrypted data format")
+	}
+	if _, err := fmt.Sscanf(fields[1]+" "+fields[2]+" "+fields[3], "%d %d %d", &params.ScryptN, &params.ScryptR, &params.ScryptP); err != nil {
+		return params, "", fmt.Errorf("invalid kdf parameters: %v", err)
+	}
+	return params, fields[4], nil
 }
 
-func decrypt(encryptedData, password string) (string, error) {
+// decrypt opens data written by encrypt. The associated data is only used
+// for data marked as bound, legacy data is opened without it. The plaintext
+// is returned in a SecureBuffer for the caller to destroy.
+func decrypt(encryptedData, password string, keyfile, ad []byte) (*utils.SecureBuffer, error) {
+	if strings.HasPrefix(encryptedData, boundPrefix) {
+		encryptedData = strings.TrimPrefix(encryptedData, boundPrefix)
+	} else {
+		ad = nil
+	}
+
+	params, encryptedData, err := splitKDFParams(encryptedData)
+	if err != nil {
+		return nil, err
+	}
+
 	parts := strings.SplitN(encryptedData, ":", 2)
 	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid encrypted data format")
+		return nil, fmt.Errorf("invalid encrypted data format")
 	}
 
 	salt, err := hex.DecodeString(parts[0])
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	encrypted, err := hex.DecodeString(parts[1])
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	key, _, err := deriveKey(password, salt)
+	key, _, err := deriveKey(password, keyfile, salt, params)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer utils.Wipe(key)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	aesGCM, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	nonceSize := aesGCM.NonceSize()
 	if len(encrypted) < nonceSize {
-		return "", fmt.Errorf("encrypted data too short")
+		return nil, fmt.Errorf("encrypted data too short")
 	}
 
 	nonce, ciphertext := encrypted[:nonceSize], encrypted[nonceSize:]
-	decrypted, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	decrypted, err := aesGCM.Open(nil, nonce, ciphertext, ad)
 	if err != nil {
-		return "", err
+		return nil, errAuthentication
 	}
 
-	return string(decrypted), nil
+	return utils.SecureBytes(decrypted), nil
 }
 
-func deriveKey(password string, salt []byte) ([]byte, []byte, error) {
+// deriveKey derives an AES key from the master password. With a keyfile the
+// scrypt input is a composite of both, as in KeePass, so that neither is
+// enough on its own.
+func deriveKey(password string, keyfile, salt []byte, params models.KDFConfig) ([]byte, []byte, error) {
 	if salt == nil {
 		salt = make([]byte, 8)
 		if _, err := rand.Read(salt); err != nil {
@@ -271,7 +433,16 @@ func deriveKey(password string, salt []byte) ([]byte, []byte, error) {
 		}
 	}
 
-	key, err := scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 32)
+	secret := []byte(password)
+	if keyfile != nil {
+		passwordDigest := sha256.Sum256(secret)
+		utils.Wipe(secret)
+		secret = append(passwordDigest[:], keyfile...)
+		utils.Wipe(passwordDigest[:])
+	}
+	defer utils.Wipe(secret)
+
+	key, err := scrypt.Key(secret, salt, params.ScryptN, params.ScryptR, params.ScryptP, 32)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -279,33 +450,70 @@ func deriveKey(password string, salt []byte) ([]byte, []byte, error) {
 	return key, salt, nil
 }
 
-func saveKeystore(keystorePath string, ks *Keystore, password string) {
+// saveKeystore encrypts ks with its data key. Keystores that are still
+// encrypted with their master password get key slots first. Failures are
+// reported to the user and returned, for callers that must not go on
+// without a saved keystore.
+func saveKeystore(keystorePath string, ks *Keystore, password string) error {
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	if ks.DataKey == nil {
+		if err := initKeySlots(ks, keystoreName, password); err != nil {
+			fmt.Println("Failed to set up key slots:", err)
+			return err
+		}
+	}
+
+	attemptsRecipient, err := ensureAttemptsIdentity(ks)
+	if err != nil {
+		fmt.Println("Failed to set up failed unlock records:", err)
+		return err
+	}
+
 	data, err := json.Marshal(ks)
 	if err != nil {
 		fmt.Println("Failed to marshal keystore:", err)
-		return
+		return err
 	}
 
-	encryptedData, err := encrypt(string(data), password)
+	encryptedData, err := sealWithKey(ks.DataKey.Bytes(), data, keystoreAD(keystoreName))
+	utils.Wipe(data)
 	if err != nil {
 		fmt.Println("Failed to encrypt keystore:", err)
-		return
+		return err
 	}
 
-	if err := ioutil.WriteFile(keystorePath, []byte(encryptedData), 0644); err != nil {
+	file := models.KeystoreFile{
+		Header: models.KeystoreHeader{Version: 3, Slots: ks.Header.Slots, AttemptsRecipient: attemptsRecipient, Name: keystoreName},
+		Data:   encryptedData,
+	}
+	fileData, err := json.Marshal(file)
+	if err != nil {
+		fmt.Println("Failed to marshal keystore:", err)
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(keystorePath, fileData, utils.PrivateFileMode); err != nil {
 		fmt.Println("Failed to save keystore:", err)
+		return err
 	}
+	writeExpiryIndex(keystorePath, ks)
+	return nil
 }
 
 func createEmptyIndex(keystoreName string) {
 	indexPath := getIndexFilePath(keystoreName)
-	ioutil.WriteFile(indexPath, []byte("[]"), 0644)
+	if err := utils.WriteFileAtomic(indexPath, []byte("[]"), utils.PrivateFileMode); err != nil {
+		fmt.Println("Failed to create index file:", err)
+	}
 }
 
 func updateKeystoreIndex(keystoreName, identifier string, add bool) {
 	indexPath := getIndexFilePath(keystoreName)
 	data, err := ioutil.ReadFile(indexPath)
-	if err != nil {
+	if os.IsNotExist(err) {
+		// a lost index is rebuilt entry by entry, `repair` restores all of it
+		data = []byte("[]")
+	} else if err != nil {
 		fmt.Println("Error reading index file:", err)
 		return
 	}
@@ -343,7 +551,9 @@ func updateKeystoreIndex(keystoreName, identifier string, add bool) {
 		return
 	}
 
-	ioutil.WriteFile(indexPath, updatedData, 0644)
+	if err := utils.WriteFileAtomic(indexPath, updatedData, utils.PrivateFileMode); err != nil {
+		fmt.Println("Error writing index file:", err)
+	}
 }
 
 func getIndexFilePath(keystoreName string) string {
@@ -351,26 +561,151 @@ func getIndexFilePath(keystoreName string) string {
 	return filepath.Join(keystoreIndexJsonFileDirectoryPathShit, keystoreName+"_index.json")
 }
 
-func loadKeystore(keystorePath, password string) (*Keystore, error) {
-	encryptedData, err := ioutil.ReadFile(keystorePath)
+// readKeystoreFile reads the header and the encrypted data of a keystore
+// without decrypting anything.
+func readKeystoreFile(keystorePath string) (*models.KeystoreFile, error) {
+	data, err := ioutil.ReadFile(keystorePath)
 	if err != nil {
 		return nil, err
 	}
+	return parseKeystoreFile(data)
+}
+
+func parseKeystoreFile(data []byte) (*models.KeystoreFile, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		// written before the header existed
+		return &models.KeystoreFile{Header: models.KeystoreHeader{Version: 1}, Data: string(data)}, nil
+	}
 
-	data, err := decrypt(string(encryptedData), password)
+	var file models.KeystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keystore header: %v", err)
+	}
+	if file.Header.Version != 2 && file.Header.Version != 3 {
+		return nil, fmt.Errorf("unsupported keystore version %d", file.Header.Version)
+	}
+	return &file, nil
+}
+
+func loadKeystore(keystorePath, password string) (*Keystore, error) {
+	file, err := readKeystoreFile(keystorePath)
 	if err != nil {
 		return nil, err
 	}
 
+	keystoreID := filepath.Base(keystorePath)
+	keystoreName := keystoreNameFromID(keystoreID)
+	if file.Header.Name != "" && file.Header.Name != keystoreName {
+		// keystores written before the name was recorded fail like a wrong
+		// password instead
+		return nil, fmt.Errorf("%w: it is bound to the name %q, rename it back to open it", errRenamed, file.Header.Name)
+	}
+
+	var ks *Keystore
+	if len(file.Header.Slots) > 0 {
+		ks, err = loadSlottedKeystore(file, keystorePath, keystoreName, password)
+	} else {
+		ks, err = loadPasswordKeystore(file, keystorePath, keystoreName, password)
+	}
+	switch {
+	case errors.Is(err, errWrongPassword):
+		// a password from the session that no longer works is not offered
+		// again
+		forgetKeystorePassword(keystoreID)
+	case err == nil && freshPassword:
+		// only a password that unlocked is kept for the session
+		storeKeystorePassword(keystoreID, password)
+	}
+	return ks, err
+}
+
+// loadPasswordKeystore opens a keystore that is encrypted with its master
+// password and has no key slots yet.
+func loadPasswordKeystore(file *models.KeystoreFile, keystorePath, keystoreName, password string) (*Keystore, error) {
+	var keyfile []byte
+	if file.Header.Keyfile {
+		var err error
+		if keyfile, err = keyfileFor(keystoreName); err != nil {
+			return nil, err
+		}
+	}
+
+	waitForUnlockDelay(keystorePath)
+	data, err := decrypt(file.Data, password, keyfile, keystoreAD(keystoreName))
+	if errors.Is(err, errAuthentication) {
+		// without key slots a wrong password and a modified file look the
+		// same, but the password is by far the likelier cause
+		recordFailedUnlock(keystorePath, file.Header)
+		return nil, errWrongPassword
+	}
+	if err != nil {
+		return nil, corrupted(err)
+	}
+	defer data.Destroy()
+
 	var ks Keystore
-	if err := json.Unmarshal([]byte(data), &ks); err != nil {
+	if err := json.Unmarshal(data.Bytes(), &ks); err != nil {
+		return nil, corrupted(err)
+	}
+	ks.Keyfile = keyfile
+	reportFailedUnlocks(keystorePath, &ks)
+
+	return &ks, nil
+}
+
+func loadSlottedKeystore(file *models.KeystoreFile, keystorePath, keystoreName, password string) (*Keystore, error) {
+	dataKey, slotID, ok := unlockWithIdentity(keystoreName, file.Header)
+	var keyfile []byte
+	if !ok {
+		waitForUnlockDelay(keystorePath)
+		var err error
+		dataKey, slotID, keyfile, err = unlockSlots(keystoreName, file.Header, password)
+		if errors.Is(err, errWrongPassword) {
+			recordFailedUnlock(keystorePath, file.Header)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ks, err := openSlottedKeystore(file, keystoreName, dataKey, slotID, keyfile)
+	if err != nil {
+		dataKey.Destroy()
 		return nil, err
 	}
+	reportFailedUnlocks(keystorePath, ks)
+	return ks, nil
+}
+
+// openSlottedKeystore decrypts a keystore with the data key taken from the
+// given slot.
+func openSlottedKeystore(file *models.KeystoreFile, keystoreName string, dataKey *utils.SecureBuffer, slotID int, keyfile []byte) (*Keystore, error) {
+	// the key opened a slot, so the keystore itself has to be damaged
+	data, err := openWithKey(dataKey.Bytes(), file.Data, keystoreAD(keystoreName))
+	if err != nil {
+		return nil, corrupted(err)
+	}
+	defer data.Destroy()
+
+	var ks Keystore
+	if err := json.Unmarshal(data.Bytes(), &ks); err != nil {
+		return nil, corrupted(err)
+	}
+	ks.Header = file.Header
+	ks.DataKey = dataKey
+	ks.UnlockedSlot = slotID
+	ks.Keyfile = keyfile
 
 	return &ks, nil
 }
 
 func ListAllKeystores(listDataDir string) {
+	if states.GlobalConfig.Output == "json" {
+		listAllKeystoresJSON(listDataDir)
+		return
+	}
+
 	fmt.Println("SnowPass")
 	files, err := ioutil.ReadDir(listDataDir)
 	if err != nil {
@@ -378,13 +713,10 @@ func ListAllKeystores(listDataDir string) {
 		return
 	}
 
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" && !strings.Contains(file.Name(), "_index") {
-			keystoreName := strings.TrimSuffix(file.Name(), ".json")
-			fmt.Printf("└── ")
-			color.Blue(keystoreName)
-			listKeystore(keystoreName)
-		}
+	for _, keystoreName := range keystoreNames(files) {
+		fmt.Printf("└── ")
+		color.Blue(keystoreName)
+		listKeystore(keystoreName)
 	}
 
 	fmt.Println("\n\n========== DEBUG ============")
@@ -394,6 +726,55 @@ func ListAllKeystores(listDataDir string) {
 	fmt.Println("======== END DEBUG ==========")
 }
 
+func listAllKeystoresJSON(listDataDir string) {
+	files, err := ioutil.ReadDir(listDataDir)
+	if err != nil {
+		fmt.Println("Failed to read user data directory:", err)
+		return
+	}
+
+	keystores := make(map[string][]string)
+	for _, keystoreName := range keystoreNames(files) {
+		identifiers, err := readKeystoreIndex(keystoreName)
+		if err != nil {
+			identifiers = []string{}
+		}
+		keystores[keystoreName] = identifiers
+	}
+
+	data, err := json.MarshalIndent(keystores, "", "  ")
+	if err != nil {
+		fmt.Println("Failed to marshal keystore list:", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// keystoreNames picks the keystores out of a data directory listing. Only
+// the "_index" suffix marks an index file, keystore names cannot end in it.
+func keystoreNames(files []os.FileInfo) []string {
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" && !strings.HasSuffix(file.Name(), "_index.json") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return names
+}
+
+func readKeystoreIndex(keystoreName string) ([]string, error) {
+	data, err := ioutil.ReadFile(getIndexFilePath(keystoreName))
+	if err != nil {
+		return nil, err
+	}
+
+	var identifiers []string
+	if err := json.Unmarshal(data, &identifiers); err != nil {
+		return nil, err
+	}
+	return identifiers, nil
+}
+
 func listKeystore(keystoreName string) {
 	indexPath := getIndexFilePath(keystoreName)
 	data, err := ioutil.ReadFile(indexPath)
@@ -427,20 +808,39 @@ func EditInKeystore(keystorePath, identifier string) {
 		return
 	}
 
+	passphrase := ""
+	if _, exists := ks.Passwords[identifier]; exists {
+		// the current value is only opened for the checks and the passphrase
+		oldData, oldPassphrase, err := openEntry(keystorePath, ks, password, identifier)
+		if err != nil {
+			fmt.Println("Failed to decrypt data:", err)
+			return
+		}
+		oldData.Destroy()
+		passphrase = oldPassphrase
+	}
+
 	fmt.Println("Enter new data for", identifier, ":")
 	newData, err := promptForData()
 	if err != nil {
 		fmt.Println("Error reading new data:", err)
 		return
 	}
+	defer newData.Destroy()
+	if !checkEntryStrength(newData.String(), keystoreNameFromID(keystoreID), identifier) {
+		return
+	}
+	warnIfBreached(newData.String())
 
-	encryptedData, err := encrypt(newData, password)
+	encryptedData, err := sealEntry(ks, newData.Bytes(), password, keystoreNameFromID(keystoreID), identifier, passphrase)
 	if err != nil {
 		fmt.Println("Error encrypting new data:", err)
 		return
 	}
 
 	ks.Passwords[identifier] = encryptedData
+	touchEntry(ks, identifier)
+	logWrite(keystorePath, ks, logEdit, identifier)
 	saveKeystore(keystorePath, ks, password)
 }
 
@@ -463,8 +863,15 @@ func DeleteFromKeystore(keystorePath, identifier string, keystoreName string) {
 		fmt.Println("Identifier does not exist in keystore.")
 		return
 	}
+	if err := authorizeEntry(keystorePath, ks, identifier); err != nil {
+		fmt.Println("Failed to confirm password:", err)
+		return
+	}
 
 	delete(ks.Passwords, identifier)
+	delete(ks.Modified, identifier)
+	delete(ks.Entries, identifier)
+	logWrite(keystorePath, ks, logDelete, identifier)
 	saveKeystore(keystorePath, ks, password)
 	updateKeystoreIndex(keystoreName, identifier, false)
 }
@@ -485,24 +892,23 @@ func CopyToClipboard(keystorePath, identifier string) {
 		return
 	}
 
-	encryptedData, exists := ks.Passwords[identifier]
-	if !exists {
+	if _, exists := ks.Passwords[identifier]; !exists {
 		fmt.Println("Identifier not found.")
 		return
 	}
 
-	data, err := decrypt(encryptedData, password)
+	data, _, err := openEntry(keystorePath, ks, password, identifier)
 	if err != nil {
 		fmt.Println("Failed to decrypt data:", err)
 		return
 	}
+	defer data.Destroy()
 
-	if notErr := clipboard.WriteAll(data); notErr != nil {
-		fmt.Println("Error copying to clipboard:", err)
+	warnIfPastDue(ks, keystoreNameFromID(keystoreID), identifier)
+	if !copyToClipboard(data.Bytes()) {
 		return
 	}
-
-	fmt.Println("Data copied to clipboard!")
+	logRead(keystorePath, ks, logCopy, identifier)
 	storeKeystorePassword(keystoreID, password)
 }
 
@@ -512,11 +918,24 @@ func DeleteKeystore(keystorePath string) {
 		fmt.Println("Failed to delete keystore:", err)
 		return
 	}
+
+	indexPath := getIndexFilePath(keystoreNameFromID(filepath.Base(keystorePath)))
+	if err := os.Remove(indexPath); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Failed to delete keystore index:", err)
+	}
+	os.Remove(attemptsPath(keystorePath))
+	os.Remove(accessLogPath(keystorePath))
+	os.Remove(expiryIndexPath(keystorePath))
 	fmt.Println("Keystore deleted successfully!")
 }
 
-func ChangeMasterPassword(keystorePath string) {
+// ChangeMasterPassword replaces the password of the password slot that was
+// used to unlock (the first one when a recovery key was used). A keyfile path
+// adds or rotates the keyfile of that slot, removeKeyfile drops it;
+// otherwise its current keyfile, if any, stays.
+func ChangeMasterPassword(keystorePath string, keyfilePath string, removeKeyfile bool) {
 	keystoreID := filepath.Base(keystorePath)
+	keystoreName := keystoreNameFromID(keystoreID)
 	fmt.Println("Changing master password.")
 
 	oldPassword, err := promptForPassword(false, keystoreID)
@@ -530,6 +949,14 @@ func ChangeMasterPassword(keystorePath string) {
 		fmt.Println("Failed to load keystore with old password:", err)
 		return
 	}
+	if ks.DataKey == nil {
+		if err := initKeySlots(ks, keystoreName, oldPassword); err != nil {
+			fmt.Println("Failed to set up key slots:", err)
+			return
+		}
+	}
+
+	slot := passwordSlotToChange(ks)
 
 	setBypassSessionCheck(true) // Force a new password prompt
 	newPassword, err := promptForPassword(true, keystoreID)
@@ -538,40 +965,93 @@ func ChangeMasterPassword(keystorePath string) {
 		fmt.Println("Failed to set new password:", err)
 		return
 	}
+	if !checkPasswordStrength(newPassword, currentConfig().Strength.MinScore, "strength.min_score", keystoreName) {
+		return
+	}
 
-	// Re-encrypt everything with the new password
-	for id, encryptedData := range ks.Passwords {
-		data, err := decrypt(encryptedData, oldPassword)
+	var keyfile []byte
+	switch {
+	case keyfilePath != "":
+		keyfile, err = newKeyfile(keyfilePath)
 		if err != nil {
-			fmt.Printf("Failed to decrypt data for %s: %v\n", id, err)
+			fmt.Println("Failed to read keyfile:", err)
 			return
 		}
-
-		newEncryptedData, err := encrypt(data, newPassword)
+	case removeKeyfile:
+		if !slot.Keyfile {
+			fmt.Println("This keystore does not use a keyfile.")
+			return
+		}
+	case slot.Keyfile:
+		keyfile, err = keyfileFor(keystoreName)
 		if err != nil {
-			fmt.Printf("Failed to re-encrypt data for %s: %v\n", id, err)
+			fmt.Println("Failed to read keyfile:", err)
 			return
 		}
+	}
 
-		ks.Passwords[id] = newEncryptedData
+	if err := wrapDataKey(ks, keystoreName, slot, newPassword, keyfile); err != nil {
+		fmt.Println("Failed to encrypt the new password slot:", err)
+		return
 	}
 
+	logWrite(keystorePath, ks, logChangePassword, "")
 	saveKeystore(keystorePath, ks, newPassword)
 	storeKeystorePassword(keystoreID, newPassword)
 	fmt.Println("Master password changed successfully")
 }
 
+// passwordSlotToChange returns the password slot change-password works on,
+// adding one if the keystore only has recovery slots.
+func passwordSlotToChange(ks *Keystore) *models.KeySlot {
+	if i := findSlot(ks, ks.UnlockedSlot); i >= 0 && ks.Header.Slots[i].Type == slotPassword {
+		return &ks.Header.Slots[i]
+	}
+	for i := range ks.Header.Slots {
+		if ks.Header.Slots[i].Type == slotPassword {
+			return &ks.Header.Slots[i]
+		}
+	}
+	ks.Header.Slots = append(ks.Header.Slots, models.KeySlot{ID: nextSlotID(ks), Type: slotPassword, Created: time.Now().UTC()})
+	return &ks.Header.Slots[len(ks.Header.Slots)-1]
+}
+
+// sessionNamespace keeps sessions of different profiles and stores apart in
+// the keyring. A profile of one store is not the profile of the same name in
+// another, so both go into it. It is empty for the default store so that
+// existing sessions keep working.
+func sessionNamespace() string {
+	namespace := ""
+	if states.GlobalProfile != "" {
+		namespace = "profile_" + states.GlobalProfile + "_"
+	}
+	if states.GlobalStoreOverride != "" {
+		sum := sha256.Sum256([]byte(states.GlobalDataDirectory))
+		namespace += "store_" + hex.EncodeToString(sum[:8]) + "_"
+	}
+	return namespace
+}
+
 func storeKeystorePassword(keystoreID, password string) {
-	passwordKey := "keystorePassword_" + keystoreID
-	timestampKey := "timestamp_" + keystoreID
+	if password == "" {
+		// unlocked with an identity, there is nothing to remember
+		return
+	}
+	passwordKey := sessionNamespace() + "keystorePassword_" + keystoreID
+	timestampKey := sessionNamespace() + "timestamp_" + keystoreID
 
 	utils.SetKeyringItem(passwordKey, []byte(password))
 	utils.SetKeyringItem(timestampKey, []byte(time.Now().Format(time.RFC3339)))
 }
 
+func forgetKeystorePassword(keystoreID string) {
+	utils.RemoveKeyringItem(sessionNamespace() + "keystorePassword_" + keystoreID)
+	utils.RemoveKeyringItem(sessionNamespace() + "timestamp_" + keystoreID)
+}
+
 func getKeystorePassword(keystoreID string) (string, error) {
-	passwordKey := "keystorePassword_" + keystoreID
-	timestampKey := "timestamp_" + keystoreID
+	passwordKey := sessionNamespace() + "keystorePassword_" + keystoreID
+	timestampKey := sessionNamespace() + "timestamp_" + keystoreID
 
 	tsData, err := utils.GetKeyringItem(timestampKey)
 	if err != nil {
@@ -583,7 +1063,8 @@ func getKeystorePassword(keystoreID string) (string, error) {
 		return "", fmt.Errorf("could not parse timestamp: %v", err)
 	}
 
-	if time.Since(timestamp) > 20*time.Minute {
+	sessionTimeout := states.GlobalConfig.ForKeystore(keystoreNameFromID(keystoreID)).SessionTimeout.Duration
+	if time.Since(timestamp) > sessionTimeout {
 		utils.RemoveKeyringItem(passwordKey)
 		utils.RemoveKeyringItem(timestampKey)
 		return "", fmt.Errorf("session expired")
@@ -594,5 +1075,5 @@ func getKeystorePassword(keystoreID string) (string, error) {
 		return "", fmt.Errorf("could not find password in keyring: %v", err)
 	}
 
-	return string(pwData), nil
+	return utils.SecureBytes(pwData).String(), nil
 }
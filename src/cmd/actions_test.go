@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/fluffysnowman/snowpass/states"
+)
+
+// cheapKDF keeps scrypt fast for the duration of a test.
+func cheapKDF(t *testing.T) {
+	t.Helper()
+	saved := states.GlobalConfig.KDF
+	states.GlobalConfig.KDF.ScryptN = 1 << 10
+	t.Cleanup(func() { states.GlobalConfig.KDF = saved })
+}
+
+func checkEntryBinding(t *testing.T, ks *Keystore) {
+	t.Helper()
+	encrypted, err := encryptEntry(ks, []byte("hunter2"), "pw", "work", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := decryptEntry(ks, encrypted, "pw", "work", "db")
+	if err != nil {
+		t.Fatalf("entry does not decrypt where it was written: %v", err)
+	}
+	if got := string(data.Bytes()); got != "hunter2" {
+		t.Errorf("decrypted %q, want %q", got, "hunter2")
+	}
+	data.Destroy()
+
+	moves := []struct{ keystore, identifier string }{
+		{"work", "db2"}, // another entry
+		{"home", "db"},  // another keystore
+		{"work/d", "b"}, // the same bytes split differently
+		{"workdb", ""},  // the name and identifier run together
+	}
+	for _, move := range moves {
+		if data, err := decryptEntry(ks, encrypted, "pw", move.keystore, move.identifier); err == nil {
+			data.Destroy()
+			t.Errorf("entry of work/db decrypts as %s/%s", move.keystore, move.identifier)
+		}
+	}
+}
+
+func TestEntryBindingWithPassword(t *testing.T) {
+	cheapKDF(t)
+	checkEntryBinding(t, &Keystore{Passwords: map[string]string{}, BoundEntries: true})
+}
+
+func TestEntryBindingWithDataKey(t *testing.T) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntryBinding(t, &Keystore{Passwords: map[string]string{}, DataKey: dataKey})
+}
+
+func TestUnboundEntries(t *testing.T) {
+	cheapKDF(t)
+	legacy, err := encrypt([]byte("hunter2"), "pw", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read until the keystore has been upgraded
+	ks := &Keystore{Passwords: map[string]string{}}
+	data, err := decryptEntry(ks, legacy, "pw", "work", "db")
+	if err != nil {
+		t.Fatalf("legacy entry does not decrypt: %v", err)
+	}
+	data.Destroy()
+
+	// and refused afterwards, with either kind of key
+	ks.BoundEntries = true
+	if _, err := decryptEntry(ks, legacy, "pw", "work", "db"); err == nil {
+		t.Error("unbound entry decrypts in a keystore whose entries are bound")
+	}
+	ks.DataKey, err = newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptEntry(ks, legacy, "pw", "work", "db"); err == nil {
+		t.Error("entry encrypted with the password decrypts in a keystore with a data key")
+	}
+}
+
+func TestSessionNamespace(t *testing.T) {
+	saved := []string{states.GlobalProfile, states.GlobalStoreOverride, states.GlobalDataDirectory}
+	t.Cleanup(func() {
+		states.GlobalProfile, states.GlobalStoreOverride, states.GlobalDataDirectory = saved[0], saved[1], saved[2]
+	})
+
+	namespaces := make(map[string]string)
+	for _, c := range []struct{ profile, store string }{
+		{"", ""},
+		{"ops", ""},
+		{"", "/mnt/a"},
+		{"", "/mnt/b"},
+		{"ops", "/mnt/a"},
+		{"ops", "/mnt/b"},
+		{"dev", "/mnt/a"},
+	} {
+		states.GlobalProfile, states.GlobalStoreOverride = c.profile, c.store
+		states.GlobalDataDirectory = c.store + "/profiles/" + c.profile
+		namespace := sessionNamespace()
+		if other, taken := namespaces[namespace]; taken {
+			t.Errorf("profile %q in store %q shares the namespace %q with %s", c.profile, c.store, namespace, other)
+		}
+		namespaces[namespace] = fmt.Sprintf("profile %q in store %q", c.profile, c.store)
+	}
+
+	// sessions of the default store and of its profiles stay where they were
+	states.GlobalProfile, states.GlobalStoreOverride = "", ""
+	if got := sessionNamespace(); got != "" {
+		t.Errorf("sessionNamespace() = %q for the default store, want \"\"", got)
+	}
+	states.GlobalProfile = "ops"
+	if got := sessionNamespace(); got != "profile_ops_" {
+		t.Errorf("sessionNamespace() = %q for a profile of the default store, want %q", got, "profile_ops_")
+	}
+}
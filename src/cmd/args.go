@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// entryConnectors lists the words accepted between the identifier and the
+// keystore for each mode, e.g. `add [identifier] to [keystore]`.
+var entryConnectors = map[string][]string{
+	"add":       {"to"},
+	"get":       {"from"},
+	"copy":      {"from"},
+	"edit":      {"in", "from"},
+	"delete":    {"from"},
+	"otp":       {"from"},
+	"share":     {"from"},
+	"receive":   {"to"},
+	"protect":   {"in"},
+	"unprotect": {"in"},
+	"expiry":    {"in"},
+}
+
+func isConnectorWord(word string) bool {
+	switch word {
+	case "to", "from", "in":
+		return true
+	}
+	return false
+}
+
+func entryUsage(mode string) string {
+	connector := "from"
+	if connectors, ok := entryConnectors[mode]; ok {
+		connector = connectors[0]
+	}
+	if mode == "receive" {
+		return fmt.Sprintf("snowpass %s [bundle] [%s [keystore]]", mode, connector)
+	}
+	return fmt.Sprintf("snowpass %s [identifier] [%s [keystore]]", mode, connector)
+}
+
+// KeystorePath checks a keystore name given by the user and returns it
+// normalized together with the path of its file. Every command turns names
+// into paths through it, so that no name reaches outside the data directory.
+func KeystorePath(dataDir, keystoreName string) (string, string, error) {
+	name, err := utils.NormalizeKeystoreName(keystoreName)
+	if err != nil {
+		return "", "", err
+	}
+	return name, filepath.Join(dataDir, name+".json"), nil
+}
+
+// normalizeKeystoreNames applies KeystorePath's checks to a list of names.
+func normalizeKeystoreNames(names []string) ([]string, error) {
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		var err error
+		if normalized[i], err = utils.NormalizeKeystoreName(name); err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
+
+// ResolveEntryArgs works out the identifier and keystore for commands that
+// act on a single entry. Both `get X from Y` and, when a default keystore is
+// configured, `get X` are accepted. Both names come back normalized, except
+// for `receive`, whose first argument is the bundle.
+func ResolveEntryArgs(mode string, args []string, dataDir string) (string, string, error) {
+	identifier, keystoreName, err := resolveEntryArgs(mode, args, dataDir)
+	if err != nil {
+		return "", "", err
+	}
+	if keystoreName, err = utils.NormalizeKeystoreName(keystoreName); err != nil {
+		return "", "", err
+	}
+	if mode != "receive" {
+		if identifier, err = utils.NormalizeIdentifier(identifier); err != nil {
+			return "", "", err
+		}
+	}
+	return identifier, keystoreName, nil
+}
+
+func resolveEntryArgs(mode string, args []string, dataDir string) (string, string, error) {
+	connectors := entryConnectors[mode]
+
+	switch len(args) {
+	case 3:
+		identifier, connector, keystoreName := args[0], args[1], args[2]
+		valid := false
+		for _, c := range connectors {
+			if connector == c {
+				valid = true
+			}
+		}
+		if !valid {
+			return "", "", fmt.Errorf("expected %q after the identifier, got %q\nUsage: %s", connectors[0], connector, entryUsage(mode))
+		}
+		if isConnectorWord(identifier) {
+			return "", "", fmt.Errorf("missing identifier before %q\nUsage: %s", connector, entryUsage(mode))
+		}
+		return identifier, keystoreName, nil
+	case 2:
+		if isConnectorWord(args[1]) {
+			return "", "", fmt.Errorf("missing keystore after %q\nUsage: %s", args[1], entryUsage(mode))
+		}
+		return "", "", fmt.Errorf("unexpected argument %q\nUsage: %s", args[1], entryUsage(mode))
+	case 1:
+		identifier := args[0]
+		if isConnectorWord(identifier) {
+			return "", "", fmt.Errorf("missing identifier\nUsage: %s", entryUsage(mode))
+		}
+
+		keystoreName := states.GlobalConfig.DefaultKeystore
+		if keystoreName == "" {
+			return "", "", fmt.Errorf("no keystore given and no default keystore set\nUse `snowpass use [keystore]` or: %s", entryUsage(mode))
+		}
+		if !keystoreExists(dataDir, keystoreName) {
+			return "", "", fmt.Errorf("default keystore %q does not exist\nUse `snowpass use [keystore]` to pick another one", keystoreName)
+		}
+
+		// `sp get work` is most likely a forgotten identifier rather than an
+		// entry called "work" in the default keystore.
+		if mode != "add" && mode != "receive" && keystoreExists(dataDir, identifier) && !indexContains(keystoreName, identifier) {
+			return "", "", fmt.Errorf("%q is a keystore, not an identifier in the default keystore %q\nUse `snowpass %s [identifier] %s %s`", identifier, keystoreName, mode, connectors[0], identifier)
+		}
+		return identifier, keystoreName, nil
+	default:
+		return "", "", fmt.Errorf("Usage: %s", entryUsage(mode))
+	}
+}
+
+func keystoreExists(dataDir, keystoreName string) bool {
+	_, keystorePath, err := KeystorePath(dataDir, keystoreName)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(keystorePath)
+	return err == nil
+}
+
+func indexContains(keystoreName, identifier string) bool {
+	identifiers, err := readKeystoreIndex(keystoreName)
+	if err != nil {
+		return false
+	}
+	for _, id := range identifiers {
+		if id == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// UseKeystore sets or shows the default keystore.
+func UseKeystore(dataDir string, args []string) {
+	if len(args) == 0 {
+		if states.GlobalConfig.DefaultKeystore == "" {
+			fmt.Println("No default keystore set.")
+			return
+		}
+		fmt.Println(states.GlobalConfig.DefaultKeystore)
+		return
+	}
+
+	keystoreName, err := utils.NormalizeKeystoreName(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !keystoreExists(dataDir, keystoreName) {
+		fmt.Printf("Keystore %q does not exist.\n", keystoreName)
+		return
+	}
+
+	key := "default_keystore"
+	if states.GlobalProfile != "" {
+		key = "profiles." + states.GlobalProfile + ".default_keystore"
+	}
+	if err := utils.SetConfigValue(key, keystoreName); err != nil {
+		fmt.Println("Failed to set default keystore:", err)
+		return
+	}
+	fmt.Printf("Default keystore set to %s\n", keystoreName)
+}
+
+// ParseGlobalFlags removes --store and --profile (in either `--flag value` or
+// `--flag=value` form) from the arguments and records them in states. The
+// SNOWPASS_HOME and SNOWPASS_PROFILE environment variables are used when the
+// flags are absent. Everything after a bare `--` is left untouched.
+func ParseGlobalFlags(args []string) ([]string, error) {
+	var rest []string
+	store := os.Getenv("SNOWPASS_HOME")
+	profile := os.Getenv("SNOWPASS_PROFILE")
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		var target *string
+		switch {
+		case arg == "--store" || strings.HasPrefix(arg, "--store="):
+			target = &store
+		case arg == "--profile" || strings.HasPrefix(arg, "--profile="):
+			target = &profile
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+
+		if name, value, found := strings.Cut(arg, "="); found {
+			if value == "" {
+				return nil, fmt.Errorf("%s needs a value", name)
+			}
+			*target = value
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("%s needs a value", arg)
+		}
+		i++
+		*target = args[i]
+	}
+
+	if profile != "" && !isValidProfileName(profile) {
+		return nil, fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", profile)
+	}
+	if store != "" {
+		abs, err := filepath.Abs(store)
+		if err != nil {
+			return nil, err
+		}
+		store = abs
+	}
+
+	states.GlobalStoreOverride = store
+	states.GlobalProfile = profile
+	return rest, nil
+}
+
+func isValidProfileName(name string) bool {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return name != ""
+}
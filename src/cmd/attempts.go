@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+var (
+	// errWrongPassword means the password, or the keyfile, does not unlock
+	// the keystore. Any other error from loadKeystore means the file could
+	// not be read or is damaged.
+	errWrongPassword = errors.New("wrong password")
+
+	// errRenamed means the keystore file was written under another name.
+	// It is not a failed unlock, no password would open it.
+	errRenamed = errors.New("the keystore file has been renamed")
+
+	// errAuthentication is returned by decrypt and openWithKey when the
+	// GCM tag does not match.
+	errAuthentication = errors.New("message authentication failed")
+)
+
+type corruptedError struct {
+	err error
+}
+
+func (e corruptedError) Error() string {
+	return "the keystore file is damaged: " + e.err.Error()
+}
+
+func (e corruptedError) Unwrap() error {
+	return e.err
+}
+
+func corrupted(err error) error {
+	return corruptedError{err}
+}
+
+// maxAttemptRecords bounds the attempts file when nobody unlocks the
+// keystore for a long time; the count keeps going up.
+const maxAttemptRecords = 100
+
+// Failed unlocks are kept in "<keystore>.attempts" next to the keystore
+// until the next successful unlock shows them. The count and the time of the
+// last failure are in the clear so the delay can be enforced before the
+// keystore is open; who failed and when is encrypted to the recipient in the
+// keystore header, whose identity is only stored inside the keystore.
+type attemptsFile struct {
+	Count   int
+	Last    time.Time
+	Records []string `json:",omitempty"`
+}
+
+type attemptRecord struct {
+	Time time.Time
+	User string
+	Host string
+}
+
+func attemptsPath(keystorePath string) string {
+	return strings.TrimSuffix(keystorePath, ".json") + ".attempts"
+}
+
+func readAttempts(keystorePath string) (attemptsFile, error) {
+	var attempts attemptsFile
+	data, err := os.ReadFile(attemptsPath(keystorePath))
+	if err != nil {
+		return attempts, err
+	}
+	err = json.Unmarshal(data, &attempts)
+	return attempts, err
+}
+
+// ensureAttemptsIdentity gives ks an identity for its failed unlock records
+// if it has none yet and returns the matching recipient for the header.
+func ensureAttemptsIdentity(ks *Keystore) (string, error) {
+	if ks.AttemptsIdentity == "" {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return "", err
+		}
+		ks.AttemptsIdentity = identity.String()
+	}
+	identity, err := age.ParseX25519Identity(ks.AttemptsIdentity)
+	if err != nil {
+		return "", err
+	}
+	return identity.Recipient().String(), nil
+}
+
+// unlockDelay is how long to wait after the last failure, given the number
+// of failures so far.
+func unlockDelay(failures int, config models.UnlockConfig) time.Duration {
+	if config.Delay.Duration <= 0 || failures < config.FreeAttempts {
+		return 0
+	}
+	// without a maximum the doubling stops after a day
+	limit := config.MaxDelay.Duration
+	if limit <= 0 {
+		limit = 24 * time.Hour
+	}
+	delay := config.Delay.Duration
+	for i := config.FreeAttempts; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	if config.MaxDelay.Duration > 0 && delay > config.MaxDelay.Duration {
+		delay = config.MaxDelay.Duration
+	}
+	return delay
+}
+
+// waitForUnlockDelay sleeps until enough time has passed since the last
+// failed unlock of the keystore.
+func waitForUnlockDelay(keystorePath string) {
+	attempts, err := readAttempts(keystorePath)
+	if err != nil {
+		return
+	}
+	remaining := unlockDelay(attempts.Count, states.GlobalConfig.Unlock) - time.Since(attempts.Last)
+	if remaining <= 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d failed unlock attempts, waiting %v.\n", attempts.Count, remaining.Round(time.Second))
+	time.Sleep(remaining)
+}
+
+func recordFailedUnlock(keystorePath string, header models.KeystoreHeader) {
+	attempts, _ := readAttempts(keystorePath)
+	attempts.Count++
+	attempts.Last = time.Now().UTC()
+
+	if header.AttemptsRecipient != "" {
+		record := attemptRecord{Time: attempts.Last}
+		record.User, record.Host = currentUserAndHost()
+
+		recipient, err := age.ParseX25519Recipient(header.AttemptsRecipient)
+		var data []byte
+		if err == nil {
+			data, err = json.Marshal(record)
+		}
+		var sealed string
+		if err == nil {
+			sealed, err = utils.AgeEncrypt(data, recipient)
+		}
+		if err == nil {
+			attempts.Records = append(attempts.Records, sealed)
+		}
+		if len(attempts.Records) > maxAttemptRecords {
+			attempts.Records = attempts.Records[len(attempts.Records)-maxAttemptRecords:]
+		}
+	}
+
+	data, err := json.Marshal(attempts)
+	if err == nil {
+		err = utils.WriteFileAtomic(attemptsPath(keystorePath), data, utils.PrivateFileMode)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to record the failed unlock:", err)
+	}
+}
+
+// reportFailedUnlocks shows the failed unlocks since the last successful one
+// and starts counting from zero again. It writes to stderr, so that output
+// meant for other programs stays intact.
+func reportFailedUnlocks(keystorePath string, ks *Keystore) {
+	attempts, err := readAttempts(keystorePath)
+	if err != nil {
+		return
+	}
+	defer os.Remove(attemptsPath(keystorePath))
+
+	warn := color.New(color.FgYellow)
+	warn.Fprintf(os.Stderr, "%d failed unlock attempts since the last successful unlock", attempts.Count)
+	identity, err := age.ParseX25519Identity(ks.AttemptsIdentity)
+	if err != nil || len(attempts.Records) == 0 {
+		warn.Fprintf(os.Stderr, ", the last on %s.\n", attempts.Last.Local().Format("2006-01-02 15:04:05"))
+		return
+	}
+	warn.Fprintln(os.Stderr, ":")
+	for _, sealed := range attempts.Records {
+		var record attemptRecord
+		data, err := utils.AgeDecrypt(sealed, identity)
+		if err == nil {
+			err = json.Unmarshal(data.Bytes(), &record)
+			data.Destroy()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "    (unreadable record)")
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "    %s  %s@%s\n", record.Time.Local().Format("2006-01-02 15:04:05"), record.User, record.Host)
+	}
+	if hidden := attempts.Count - len(attempts.Records); hidden > 0 {
+		fmt.Fprintf(os.Stderr, "    and %d earlier ones\n", hidden)
+	}
+}
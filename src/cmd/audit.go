@@ -0,0 +1,306 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// Exit codes of `snowpass audit` and `snowpass verify`, meant for scheduled
+// CI checks.
+const (
+	ExitClean    = 0
+	ExitFindings = 1
+	ExitError    = 2
+)
+
+type auditWeak struct {
+	Entry string `json:"entry"`
+	Score int    `json:"score"`
+	Label string `json:"label"`
+}
+
+type auditStale struct {
+	Entry string `json:"entry"`
+	Days  int    `json:"days"`
+}
+
+type auditDrift struct {
+	Keystore       string   `json:"keystore"`
+	MissingInIndex []string `json:"missing_in_index"`
+	OnlyInIndex    []string `json:"only_in_index"`
+}
+
+type auditReport struct {
+	Keystores  []string     `json:"keystores"`
+	Entries    int          `json:"entries"`
+	Reused     [][]string   `json:"reused"`
+	Weak       []auditWeak  `json:"weak"`
+	Stale      []auditStale `json:"stale"`
+	StaleDays  int          `json:"stale_days"`
+	Undated    []string     `json:"undated"`
+	Empty      []string     `json:"empty"`
+	IndexDrift []auditDrift `json:"index_drift"`
+	Errors     []string     `json:"errors"`
+}
+
+func (r *auditReport) findings() int {
+	return len(r.Reused) + len(r.Weak) + len(r.Stale) + len(r.Empty) + len(r.IndexDrift)
+}
+
+// Audit decrypts the given keystores (or all of them) and reports reused,
+// weak, stale and empty values as well as index drift. It returns one of the
+// Exit* codes.
+func Audit(dataDir string, args []string) int {
+	all := false
+	jsonOutput := states.GlobalConfig.Output == "json"
+	staleDays := 180
+	var names []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--all":
+			all = true
+		case "--json":
+			jsonOutput = true
+		case "--stale-days":
+			if i+1 >= len(args) {
+				fmt.Println("--stale-days needs a value")
+				return ExitError
+			}
+			i++
+			var err error
+			if staleDays, err = strconv.Atoi(args[i]); err != nil || staleDays < 1 {
+				fmt.Println("--stale-days must be a positive number")
+				return ExitError
+			}
+		default:
+			names = append(names, args[i])
+		}
+	}
+
+	if !all && len(names) == 0 && states.GlobalConfig.DefaultKeystore != "" {
+		// the config is checked like a name typed by the user
+		names = []string{states.GlobalConfig.DefaultKeystore}
+	}
+	names, err := normalizeKeystoreNames(names)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if all {
+		files, err := ioutil.ReadDir(dataDir)
+		if err != nil {
+			fmt.Println("Failed to read user data directory:", err)
+			return ExitError
+		}
+		names = keystoreNames(files)
+	}
+	if len(names) == 0 {
+		fmt.Println("Usage for audit: snowpass audit [keystore...|--all] [--json] [--stale-days N]")
+		return ExitError
+	}
+
+	report := auditReport{
+		Keystores:  names,
+		StaleDays:  staleDays,
+		Reused:     [][]string{},
+		Weak:       []auditWeak{},
+		Stale:      []auditStale{},
+		Undated:    []string{},
+		Empty:      []string{},
+		IndexDrift: []auditDrift{},
+		Errors:     []string{},
+	}
+
+	// values are only kept as hashes, to find reuse across keystores
+	seen := make(map[[32]byte][]string)
+
+	for _, keystoreName := range names {
+		keystoreName, keystorePath, err := KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		keystoreID := filepath.Base(keystorePath)
+		setCurrentKeystoreID(keystoreID)
+
+		fmt.Fprintf(os.Stderr, "Unlocking %s\n", keystoreName)
+		password, err := promptForPassword(false, keystoreID)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: failed to read password: %v", keystoreName, err))
+			continue
+		}
+
+		ks, err := loadKeystore(keystorePath, password)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: failed to load keystore: %v", keystoreName, err))
+			continue
+		}
+		storeKeystorePassword(keystoreID, password)
+
+		weakScore := currentConfig().Strength.WeakScore
+		for _, id := range sortedIdentifiers(ks) {
+			if entryMeta(ks, id).Passphrase {
+				// only its own passphrase opens it
+				continue
+			}
+			entry := keystoreName + "/" + id
+			report.Entries++
+
+			data, err := decryptEntry(ks, ks.Passwords[id], password, keystoreName, id)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: failed to decrypt: %v", entry, err))
+				continue
+			}
+
+			if len(data.Bytes()) == 0 {
+				report.Empty = append(report.Empty, entry)
+			} else {
+				sum := sha256.Sum256(data.Bytes())
+				seen[sum] = append(seen[sum], entry)
+
+				strength := utils.EstimateStrength(data.String(), []string{keystoreName, id})
+				if strength.Score < weakScore {
+					report.Weak = append(report.Weak, auditWeak{Entry: entry, Score: strength.Score, Label: strength.Label})
+				}
+			}
+			data.Destroy()
+
+			// entries written before modification times were kept can't be
+			// judged, so they are listed without counting as findings
+			modified, ok := ks.Modified[id]
+			if !ok {
+				report.Undated = append(report.Undated, entry)
+			} else if days := int(time.Since(modified).Hours() / 24); days >= staleDays {
+				report.Stale = append(report.Stale, auditStale{Entry: entry, Days: days})
+			}
+		}
+
+		if drift, ok := indexDrift(keystoreName, ks); ok {
+			report.IndexDrift = append(report.IndexDrift, drift)
+		}
+		// every value was read, which is recorded once for the keystore
+		logRead(keystorePath, ks, logAudit, "")
+	}
+
+	for _, entries := range seen {
+		if len(entries) > 1 {
+			report.Reused = append(report.Reused, entries)
+		}
+	}
+	sort.Slice(report.Reused, func(i, j int) bool {
+		return report.Reused[i][0] < report.Reused[j][0]
+	})
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Println("Failed to marshal report:", err)
+			return ExitError
+		}
+		fmt.Println(string(data))
+	} else {
+		printAuditReport(&report)
+	}
+
+	switch {
+	case len(report.Errors) > 0:
+		return ExitError
+	case report.findings() > 0:
+		return ExitFindings
+	}
+	return ExitClean
+}
+
+// indexDrift compares the identifiers in a keystore with its index file.
+// The second result is false when both agree.
+func indexDrift(keystoreName string, ks *Keystore) (auditDrift, bool) {
+	drift := auditDrift{Keystore: keystoreName, MissingInIndex: []string{}, OnlyInIndex: []string{}}
+
+	indexed, err := readKeystoreIndex(keystoreName)
+	if err != nil {
+		indexed = nil
+	}
+	inIndex := make(map[string]bool, len(indexed))
+	for _, id := range indexed {
+		inIndex[id] = true
+		if _, ok := ks.Passwords[id]; !ok {
+			drift.OnlyInIndex = append(drift.OnlyInIndex, id)
+		}
+	}
+	for id := range ks.Passwords {
+		if !inIndex[id] {
+			drift.MissingInIndex = append(drift.MissingInIndex, id)
+		}
+	}
+	sort.Strings(drift.MissingInIndex)
+	sort.Strings(drift.OnlyInIndex)
+
+	return drift, len(drift.MissingInIndex) > 0 || len(drift.OnlyInIndex) > 0
+}
+
+func printAuditReport(r *auditReport) {
+	fmt.Println()
+	color.Yellow("Audit of %s (%d entries)", strings.Join(r.Keystores, ", "), r.Entries)
+
+	section := func(title string, count int) bool {
+		if count == 0 {
+			color.Green("✓ %s: none", title)
+			return false
+		}
+		color.Red("✗ %s: %d", title, count)
+		return true
+	}
+
+	if section("Reused values", len(r.Reused)) {
+		for _, entries := range r.Reused {
+			fmt.Println("    " + strings.Join(entries, ", "))
+		}
+	}
+	if section("Weak values", len(r.Weak)) {
+		for _, w := range r.Weak {
+			fmt.Printf("    %s (%s, %d/4)\n", w.Entry, w.Label, w.Score)
+		}
+	}
+	if section(fmt.Sprintf("Not modified in %d days", r.StaleDays), len(r.Stale)) {
+		for _, s := range r.Stale {
+			fmt.Printf("    %s (%d days)\n", s.Entry, s.Days)
+		}
+	}
+	if len(r.Undated) > 0 {
+		fmt.Printf("  No modification date recorded (edit to start tracking): %s\n", strings.Join(r.Undated, ", "))
+	}
+	if section("Empty values", len(r.Empty)) {
+		for _, e := range r.Empty {
+			fmt.Println("    " + e)
+		}
+	}
+	if section("Index drift", len(r.IndexDrift)) {
+		for _, d := range r.IndexDrift {
+			if len(d.MissingInIndex) > 0 {
+				fmt.Printf("    %s: missing from index: %s\n", d.Keystore, strings.Join(d.MissingInIndex, ", "))
+			}
+			if len(d.OnlyInIndex) > 0 {
+				fmt.Printf("    %s: only in index: %s\n", d.Keystore, strings.Join(d.OnlyInIndex, ", "))
+			}
+		}
+	}
+	for _, e := range r.Errors {
+		color.Red("Error: %s", e)
+	}
+
+	fmt.Printf("\n%d findings\n", r.findings())
+}
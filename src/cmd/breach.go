@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// BreachCheck decrypts every entry of a keystore and looks it up in the local
+// HIBP dataset. Only identifiers are reported, never the values.
+func BreachCheck(keystorePath, hibpPath string) {
+	if hibpPath == "" {
+		hibpPath = states.GlobalConfig.HIBP.Path
+	}
+	checker, err := utils.NewBreachChecker(hibpPath)
+	if err != nil {
+		fmt.Println("Failed to open HIBP dataset:", err)
+		return
+	}
+
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+
+	identifiers := sortedIdentifiers(ks)
+
+	compromised := 0
+	for _, id := range identifiers {
+		if entryMeta(ks, id).Passphrase {
+			fmt.Printf("Skipping %s, it has a passphrase of its own.\n", id)
+			continue
+		}
+		data, err := decryptEntry(ks, ks.Passwords[id], password, keystoreNameFromID(keystoreID), id)
+		if err != nil {
+			fmt.Printf("Failed to decrypt data for %s: %v\n", id, err)
+			continue
+		}
+
+		count, err := checker.Count(data.String())
+		data.Destroy()
+		if err != nil {
+			fmt.Printf("Failed to check %s: %v\n", id, err)
+			continue
+		}
+		if count > 0 {
+			compromised++
+			color.Red("%s: found %d times in known breaches", id, count)
+		}
+	}
+
+	if compromised == 0 {
+		color.Green("None of the %d entries in %s were found in the dataset.", len(identifiers), keystoreNameFromID(keystoreID))
+	} else {
+		fmt.Printf("%d of %d entries are compromised and should be changed.\n", compromised, len(identifiers))
+	}
+	logRead(keystorePath, ks, logBreach, "")
+	storeKeystorePassword(keystoreID, password)
+}
+
+// warnIfBreached checks a new value against the HIBP dataset when
+// hibp.check_on_add is enabled. Problems with the dataset are only reported.
+func warnIfBreached(data string) {
+	if !states.GlobalConfig.HIBP.CheckOnAdd {
+		return
+	}
+
+	checker, err := utils.NewBreachChecker(states.GlobalConfig.HIBP.Path)
+	if err != nil {
+		fmt.Println("Skipping breach check:", err)
+		return
+	}
+	count, err := checker.Count(data)
+	if err != nil {
+		fmt.Println("Skipping breach check:", err)
+		return
+	}
+	if count > 0 {
+		color.Red("Warning: this value appears %d times in known breaches.", count)
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// copyToClipboard copies data and, when clipboard_timeout is set, arranges
+// for it to be cleared again. It reports whether the copy succeeded.
+func copyToClipboard(data []byte) bool {
+	// the clipboard package only takes strings, so hand it one that shares
+	// the memory of data instead of a copy that could not be wiped
+	if err := clipboard.WriteAll(utils.AliasString(data)); err != nil {
+		fmt.Println("Error copying to clipboard:", err)
+		return false
+	}
+
+	fmt.Println("Data copied to clipboard!")
+	if timeout := currentConfig().ClipboardTimeout.Duration; timeout > 0 {
+		if err := scheduleClipboardClear(data, timeout); err != nil {
+			fmt.Println("Failed to schedule clipboard clearing:", err)
+		} else {
+			fmt.Printf("Clipboard will be cleared in %v.\n", timeout)
+		}
+	}
+	return true
+}
+
+// scheduleClipboardClear starts a detached copy of snowpass that clears the
+// clipboard after the timeout, unless something else was copied meanwhile.
+// Only a hash of the data is handed over, through stdin so that it does not
+// show up in the process list.
+func scheduleClipboardClear(data []byte, timeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+
+	child := exec.Command(executable, "clear-clipboard", timeout.String())
+	child.Stdin = strings.NewReader(hex.EncodeToString(sum[:]) + "\n")
+	if err := child.Start(); err != nil {
+		return err
+	}
+	return child.Process.Release()
+}
+
+// ClearClipboardAfter is the hidden `clear-clipboard` mode used by
+// scheduleClipboardClear.
+func ClearClipboardAfter(timeoutArg string) {
+	timeout, err := time.ParseDuration(timeoutArg)
+	if err != nil {
+		return
+	}
+
+	expectedHash, err := stdin.ReadString('\n')
+	if err != nil {
+		return
+	}
+	expectedHash = strings.TrimSpace(expectedHash)
+
+	time.Sleep(timeout)
+
+	current, err := clipboard.ReadAll()
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256([]byte(current))
+	if hex.EncodeToString(sum[:]) != expectedHash {
+		return
+	}
+
+	if err := clipboard.WriteAll(""); err != nil {
+		fmt.Println("Failed to clear clipboard:", err)
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// ApplyColorSetting switches colored output on or off according to the
+// `color` config key. "auto" leaves the terminal detection of fatih/color
+// in place.
+func ApplyColorSetting() {
+	switch states.GlobalConfig.Color {
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	}
+}
+
+func ConfigCommand(args []string) {
+	if len(args) < 1 {
+		printConfigUsage()
+		return
+	}
+
+	switch args[0] {
+	case "get":
+		if len(args) != 2 {
+			printConfigUsage()
+			return
+		}
+		value, err := utils.GetConfigValue(states.GlobalConfig, args[1])
+		if err != nil {
+			fmt.Println("Failed to read config:", err)
+			return
+		}
+		fmt.Println(value)
+	case "set":
+		if len(args) != 3 {
+			printConfigUsage()
+			return
+		}
+		if err := utils.SetConfigValue(args[1], args[2]); err != nil {
+			fmt.Println("Failed to update config:", err)
+			return
+		}
+		fmt.Printf("Set %s = %s\n", args[1], args[2])
+	case "unset":
+		if len(args) != 2 {
+			printConfigUsage()
+			return
+		}
+		if err := utils.UnsetConfigValue(args[1]); err != nil {
+			fmt.Println("Failed to update config:", err)
+			return
+		}
+		fmt.Printf("Unset %s\n", args[1])
+	case "list":
+		values, err := utils.ListConfigValues(states.GlobalConfig)
+		if err != nil {
+			fmt.Println("Failed to read config:", err)
+			return
+		}
+
+		if states.GlobalConfig.Output == "json" {
+			out := make(map[string]string, len(values))
+			for _, kv := range values {
+				out[kv[0]] = kv[1]
+			}
+			data, _ := json.MarshalIndent(out, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+
+		if path, err := utils.GetConfigPath(); err == nil {
+			fmt.Println("# " + path)
+		}
+		for _, kv := range values {
+			fmt.Printf("%s = %s\n", color.CyanString(kv[0]), kv[1])
+		}
+	default:
+		printConfigUsage()
+	}
+}
+
+func printConfigUsage() {
+	fmt.Println("Usage for config: snowpass config get [key]")
+	fmt.Println("                  snowpass config set [key] [value]")
+	fmt.Println("                  snowpass config unset [key]")
+	fmt.Println("                  snowpass config list")
+}
@@ -0,0 +1,408 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// entrySchedule is what is needed to tell when an entry is due, without its
+// value. With expiry_index set it is also kept in "<keystore>.expiry" next to
+// the keystore, in the clear, so that `expiring` needs no password.
+type entrySchedule struct {
+	Expires     *time.Time       `json:",omitempty"`
+	RotateEvery *models.Duration `json:",omitempty"`
+	Modified    *time.Time       `json:",omitempty"`
+}
+
+// dueItem is an expiry or a rotation of an entry. Due is nil for a rotation
+// of an entry whose last change was never recorded.
+type dueItem struct {
+	Keystore   string     `json:"keystore"`
+	Identifier string     `json:"identifier"`
+	Kind       string     `json:"kind"`
+	Due        *time.Time `json:"due"`
+}
+
+func expiryIndexPath(keystorePath string) string {
+	return strings.TrimSuffix(keystorePath, ".json") + ".expiry"
+}
+
+func entrySchedules(ks *Keystore) map[string]entrySchedule {
+	schedules := make(map[string]entrySchedule)
+	for id, meta := range ks.Entries {
+		if meta.Expires == nil && meta.RotateEvery == nil {
+			continue
+		}
+		schedule := entrySchedule{Expires: meta.Expires, RotateEvery: meta.RotateEvery}
+		if modified, ok := ks.Modified[id]; ok {
+			schedule.Modified = &modified
+		}
+		schedules[id] = schedule
+	}
+	return schedules
+}
+
+func (s entrySchedule) due(keystoreName, identifier string) []dueItem {
+	var items []dueItem
+	if s.Expires != nil {
+		items = append(items, dueItem{Keystore: keystoreName, Identifier: identifier, Kind: "expires", Due: s.Expires})
+	}
+	if s.RotateEvery != nil {
+		item := dueItem{Keystore: keystoreName, Identifier: identifier, Kind: "rotate"}
+		if s.Modified != nil {
+			due := s.Modified.Add(s.RotateEvery.Duration)
+			item.Due = &due
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (item dueItem) describe(now time.Time) string {
+	if item.Due == nil {
+		return "is due for rotation, its last change was never recorded"
+	}
+	date := item.Due.Local().Format("2006-01-02")
+	days := fmt.Sprintf("%d days", int(math.Ceil(item.Due.Sub(now).Hours()/24)))
+	if days == "1 days" {
+		days = "1 day"
+	}
+	switch {
+	case item.Kind == "expires" && !item.Due.After(now):
+		return fmt.Sprintf("expired on %s", date)
+	case item.Kind == "expires":
+		return fmt.Sprintf("expires on %s, in %s", date, days)
+	case !item.Due.After(now):
+		return fmt.Sprintf("was due for rotation on %s", date)
+	default:
+		return fmt.Sprintf("is due for rotation on %s, in %s", date, days)
+	}
+}
+
+func (item dueItem) pastDue(now time.Time) bool {
+	return item.Due == nil || !item.Due.After(now)
+}
+
+// writeExpiryIndex keeps the expiry index of a keystore up to date after it
+// was saved, or removes it when the keystore does not want one.
+func writeExpiryIndex(keystorePath string, ks *Keystore) {
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	if !states.GlobalConfig.ForKeystore(keystoreName).ExpiryIndex {
+		os.Remove(expiryIndexPath(keystorePath))
+		return
+	}
+	data, err := json.Marshal(entrySchedules(ks))
+	if err == nil {
+		err = utils.WriteFileAtomic(expiryIndexPath(keystorePath), data, utils.PrivateFileMode)
+	}
+	if err != nil {
+		fmt.Println("Failed to write expiry index:", err)
+	}
+}
+
+// warnIfPastDue prints a banner on stderr when an entry that is handed out
+// has expired or is due for rotation.
+func warnIfPastDue(ks *Keystore, keystoreName, identifier string) {
+	schedule, ok := entrySchedules(ks)[identifier]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for _, item := range schedule.due(keystoreName, identifier) {
+		if item.pastDue(now) {
+			color.New(color.FgRed, color.Bold).Fprintf(os.Stderr, "!! %s %s\n", identifier, item.describe(now))
+		}
+	}
+}
+
+// parseExpiry reads a date ("2025-06-30"), a time in RFC 3339 or a duration
+// from now ("90d").
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if d, err := utils.ParseLongDuration(value); err == nil && d > 0 {
+		return time.Now().UTC().Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q, use a date such as 2025-06-30 or a duration such as 90d", value)
+}
+
+// SplitExpiryOptions separates --expires and --rotate-every from the
+// identifier and keystore arguments. Either can be "never" to remove it.
+func SplitExpiryOptions(args []string) (positional []string, expires, rotateEvery string, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--expires", "--rotate-every":
+			if i+1 >= len(args) {
+				return nil, "", "", fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			if arg == "--expires" {
+				expires = args[i]
+			} else {
+				rotateEvery = args[i]
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return nil, "", "", fmt.Errorf("unknown option %q", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+	return positional, expires, rotateEvery, nil
+}
+
+// SetEntryExpiry sets or removes the expiry date and rotation interval of an
+// entry. Without either it shows them.
+func SetEntryExpiry(keystorePath, identifier, expires, rotateEvery string) {
+	keystoreID := filepath.Base(keystorePath)
+	keystoreName := keystoreNameFromID(keystoreID)
+	setCurrentKeystoreID(keystoreID)
+
+	var expiresAt time.Time
+	var interval time.Duration
+	var err error
+	if expires != "" && expires != "never" {
+		if expiresAt, err = parseExpiry(expires); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if rotateEvery != "" && rotateEvery != "never" {
+		interval, err = utils.ParseLongDuration(rotateEvery)
+		if err != nil || interval == 0 {
+			fmt.Println("--rotate-every needs a duration such as 90d")
+			return
+		}
+	}
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+	if _, exists := ks.Passwords[identifier]; !exists {
+		fmt.Println("Identifier not found.")
+		return
+	}
+	storeKeystorePassword(keystoreID, password)
+
+	meta := entryMeta(ks, identifier)
+	if expires == "" && rotateEvery == "" {
+		schedule, ok := entrySchedules(ks)[identifier]
+		if !ok {
+			fmt.Printf("%s has no expiry date and no rotation interval.\n", identifier)
+			return
+		}
+		for _, item := range schedule.due(keystoreName, identifier) {
+			fmt.Printf("%s %s\n", identifier, item.describe(time.Now()))
+		}
+		return
+	}
+
+	switch expires {
+	case "":
+	case "never":
+		meta.Expires = nil
+	default:
+		meta.Expires = &expiresAt
+	}
+	switch rotateEvery {
+	case "":
+	case "never":
+		meta.RotateEvery = nil
+	default:
+		meta.RotateEvery = &models.Duration{Duration: interval}
+	}
+	setEntryMeta(ks, identifier, meta)
+
+	logWrite(keystorePath, ks, logExpiry, identifier)
+	saveKeystore(keystorePath, ks, password)
+
+	if meta.Expires != nil {
+		fmt.Printf("%s expires on %s.\n", identifier, meta.Expires.Local().Format("2006-01-02"))
+	}
+	if meta.RotateEvery != nil {
+		fmt.Printf("%s is to be rotated every %s.\n", identifier, formatLongDuration(meta.RotateEvery.Duration))
+	}
+	if meta.Expires == nil && meta.RotateEvery == nil {
+		fmt.Printf("%s has no expiry date and no rotation interval.\n", identifier)
+	}
+}
+
+func formatLongDuration(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// Expiring lists the entries of the given keystores (all by default) that
+// expire or are due for rotation within a period, 30 days unless --within
+// says otherwise, including those that are past due. Keystores with an
+// expiry index are read without a password. It returns ExitFindings when
+// anything is due.
+func Expiring(dataDir string, args []string) int {
+	within := 30 * 24 * time.Hour
+	jsonOutput := states.GlobalConfig.Output == "json"
+	var names []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--json":
+			jsonOutput = true
+		case "--within":
+			if i+1 >= len(args) {
+				fmt.Println("--within needs a duration such as 30d")
+				return ExitError
+			}
+			i++
+			d, err := utils.ParseLongDuration(args[i])
+			if err != nil {
+				fmt.Println("--within needs a duration such as 30d")
+				return ExitError
+			}
+			within = d
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				fmt.Printf("Unknown option %q\n", args[i])
+				return ExitError
+			}
+			names = append(names, args[i])
+		}
+	}
+
+	names, err := normalizeKeystoreNames(names)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if len(names) == 0 {
+		files, err := ioutil.ReadDir(dataDir)
+		if err != nil {
+			fmt.Println("Failed to read user data directory:", err)
+			return ExitError
+		}
+		names = keystoreNames(files)
+	}
+
+	now := time.Now()
+	var items []dueItem
+	failed := false
+	for _, name := range names {
+		schedules, err := readSchedules(dataDir, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		for id, schedule := range schedules {
+			for _, item := range schedule.due(name, id) {
+				if item.Due == nil || item.Due.Before(now.Add(within)) {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		// entries that were never dated come first
+		switch {
+		case items[i].Due == nil && items[j].Due != nil:
+			return true
+		case items[i].Due != nil && items[j].Due == nil:
+			return false
+		case items[i].Due != nil && !items[i].Due.Equal(*items[j].Due):
+			return items[i].Due.Before(*items[j].Due)
+		}
+		return items[i].Keystore+"/"+items[i].Identifier < items[j].Keystore+"/"+items[j].Identifier
+	})
+
+	if jsonOutput {
+		if items == nil {
+			items = []dueItem{}
+		}
+		data, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			fmt.Println("Failed to marshal report:", err)
+			return ExitError
+		}
+		fmt.Println(string(data))
+	} else if len(items) == 0 && !failed {
+		color.Green("Nothing expires or is due for rotation within %s.", formatLongDuration(within))
+	} else {
+		for _, item := range items {
+			line := fmt.Sprintf("%s/%s %s", item.Keystore, item.Identifier, item.describe(now))
+			if item.pastDue(now) {
+				color.Red(line)
+			} else {
+				color.Yellow(line)
+			}
+		}
+	}
+
+	switch {
+	case failed:
+		return ExitError
+	case len(items) > 0:
+		return ExitFindings
+	}
+	return ExitClean
+}
+
+// readSchedules reads the schedules of a keystore from its expiry index, or
+// unlocks it when it has none.
+func readSchedules(dataDir, keystoreName string) (map[string]entrySchedule, error) {
+	keystorePath := filepath.Join(dataDir, keystoreName+".json")
+	if _, err := os.Stat(keystorePath); err != nil {
+		return nil, fmt.Errorf("keystore does not exist")
+	}
+
+	if states.GlobalConfig.ForKeystore(keystoreName).ExpiryIndex {
+		if data, err := os.ReadFile(expiryIndexPath(keystorePath)); err == nil {
+			var schedules map[string]entrySchedule
+			if err := json.Unmarshal(data, &schedules); err != nil {
+				return nil, fmt.Errorf("malformed expiry index: %v", err)
+			}
+			return schedules, nil
+		}
+	}
+
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+	fmt.Fprintf(os.Stderr, "Unlocking %s\n", keystoreName)
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %v", err)
+	}
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock: %v", err)
+	}
+	storeKeystorePassword(keystoreID, password)
+	// an index that was turned on since the last change is written now
+	writeExpiryIndex(keystorePath, ks)
+	return entrySchedules(ks), nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// GeneratePasswordCommand prints (or copies) a random password.
+// Usage: snowpass generate [policy] [--copy]
+func GeneratePasswordCommand(args []string) {
+	var policySpec string
+	copyValue := false
+	for _, arg := range args {
+		switch {
+		case arg == "--copy":
+			copyValue = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Printf("Unknown option %q\n", arg)
+			return
+		default:
+			policySpec = arg
+		}
+	}
+
+	policy, err := utils.ParsePasswordPolicy(policySpec)
+	if err != nil {
+		fmt.Println("Invalid policy:", err)
+		return
+	}
+
+	password, err := utils.GeneratePassword(policy)
+	if err != nil {
+		fmt.Println("Failed to generate password:", err)
+		return
+	}
+	defer password.Destroy()
+
+	if copyValue {
+		copyToClipboard(password.Bytes())
+		return
+	}
+	os.Stdout.Write(password.Bytes())
+	fmt.Println()
+}
+
+// AddGeneratedToKeystore stores a freshly generated password under
+// identifier without ever printing it.
+func AddGeneratedToKeystore(keystorePath, identifier, keystoreName, policySpec string, copyValue bool) {
+	policy, err := utils.ParsePasswordPolicy(policySpec)
+	if err != nil {
+		fmt.Println("Invalid policy:", err)
+		return
+	}
+
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+
+	data, err := utils.GeneratePassword(policy)
+	if err != nil {
+		fmt.Println("Failed to generate password:", err)
+		return
+	}
+	defer data.Destroy()
+
+	if !addEntry(keystorePath, identifier, keystoreName, password, data.Bytes()) {
+		return
+	}
+	fmt.Printf("Stored a generated %d character value for %s (about %.0f bits).\n", policy.Length, identifier, utils.PasswordEntropy(policy))
+
+	if copyValue {
+		copyToClipboard(data.Bytes())
+	}
+}
+
+// SplitAddOptions separates `--generate[=policy]` and `--copy` from the
+// positional arguments of `add`. The policy has to be attached with "=":
+// identifiers such as "pin" or "32" are policies as well, so a separate
+// argument could be either.
+func SplitAddOptions(args []string) (positional []string, generate bool, policySpec string, copyValue bool, err error) {
+	for _, arg := range args {
+		switch {
+		case arg == "--generate":
+			generate = true
+		case strings.HasPrefix(arg, "--generate="):
+			generate = true
+			policySpec = strings.TrimPrefix(arg, "--generate=")
+		case arg == "--copy":
+			copyValue = true
+		case strings.HasPrefix(arg, "-"):
+			return nil, false, "", false, fmt.Errorf("unknown option %q", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if copyValue && !generate {
+		return nil, false, "", false, fmt.Errorf("--copy can only be used together with --generate")
+	}
+	return positional, generate, policySpec, copyValue, nil
+}
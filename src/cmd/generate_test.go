@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitAddOptions(t *testing.T) {
+	cases := []struct {
+		args       []string
+		positional []string
+		generate   bool
+		policySpec string
+		copyValue  bool
+	}{
+		{[]string{"db", "to", "work"}, []string{"db", "to", "work"}, false, "", false},
+		{[]string{"db", "to", "work", "--generate"}, []string{"db", "to", "work"}, true, "", false},
+		{[]string{"db", "to", "work", "--generate=32,no-symbols", "--copy"}, []string{"db", "to", "work"}, true, "32,no-symbols", true},
+		// identifiers that read as policies stay identifiers
+		{[]string{"--generate", "pin", "to", "work"}, []string{"pin", "to", "work"}, true, "", false},
+		{[]string{"--generate", "32", "to", "work"}, []string{"32", "to", "work"}, true, "", false},
+		{[]string{"--generate=pin", "pin", "to", "work"}, []string{"pin", "to", "work"}, true, "pin", false},
+	}
+	for _, c := range cases {
+		positional, generate, policySpec, copyValue, err := SplitAddOptions(c.args)
+		if err != nil {
+			t.Errorf("SplitAddOptions(%q): %v", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(positional, c.positional) || generate != c.generate || policySpec != c.policySpec || copyValue != c.copyValue {
+			t.Errorf("SplitAddOptions(%q) = %q, %v, %q, %v, want %q, %v, %q, %v", c.args,
+				positional, generate, policySpec, copyValue, c.positional, c.generate, c.policySpec, c.copyValue)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"db", "to", "work", "--copy"},
+		{"db", "to", "work", "--generated"},
+	} {
+		if _, _, _, _, err := SplitAddOptions(args); err == nil {
+			t.Errorf("SplitAddOptions(%q): want an error", args)
+		}
+	}
+}
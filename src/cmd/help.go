@@ -10,14 +10,27 @@ func DisplayHelp() {
 	color.Yellow("\n===================== Usage =====================\n")
 	fmt.Printf("%v\n", color.CyanString("[CREATE]"))
 	fmt.Printf("Creates a Keystore to Store Data in\n")
-	fmt.Printf("Usage:\t\tsnowpass create [%v]\n", color.GreenString("keystore"))
-	fmt.Printf("Example:\tsnowpass create %v\n\n", color.GreenString("work"))
+	fmt.Printf("Usage:\t\tsnowpass create [%v] [--keyfile %v]\n", color.GreenString("keystore"), color.GreenString("path"))
+	fmt.Printf("Example:\tsnowpass create %v\n", color.GreenString("work"))
+	fmt.Printf("Example:\tsnowpass create %v --keyfile %v\t(also needs the keyfile to unlock)\n\n", color.GreenString("prod"), color.GreenString("/media/usb/prod.key"))
 
 	fmt.Printf("%v\n", color.YellowString("[ADD]"))
 	fmt.Printf("Adds an entry to a specified Keystore\n")
 	fmt.Printf("Usage:\t\tsnowpass add %v to %v\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
 	fmt.Printf("Example:\tsnowpass add %v to %v\n\n", color.GreenString("github_token"), color.CyanString("work"))
 
+	fmt.Printf("%v\n", color.YellowString("[GENERATE]"))
+	fmt.Printf("Generates a random password, or stores one with `add ... --generate[=policy] [--copy]`\n")
+	fmt.Printf("Policy:\t\tcomma separated, e.g. %v (or %v / %v)\n", color.GreenString("32,no-symbols,no-ambiguous,min-digits=4"), color.GreenString("pin"), color.GreenString("alnum"))
+	fmt.Printf("Usage:\t\tsnowpass generate %v [--copy]\n", color.GreenString("[policy]"))
+	fmt.Printf("Example:\tsnowpass generate %v\n", color.GreenString("24"))
+	fmt.Printf("Example:\tsnowpass add %v to %v --generate=%v --copy\n\n", color.GreenString("db_password"), color.CyanString("work"), color.GreenString("32,no-symbols"))
+
+	fmt.Printf("%v\n", color.YellowString("[PASSPHRASE]"))
+	fmt.Printf("Generates a diceware passphrase from the EFF large wordlist and shows its entropy\n")
+	fmt.Printf("Usage:\t\tsnowpass passphrase %v [--separator S] [--capitalize] [--digit] [--copy]\n", color.GreenString("[words]"))
+	fmt.Printf("Example:\tsnowpass passphrase %v --capitalize --digit\n\n", color.GreenString("7"))
+
 	fmt.Printf("%v\n", color.MagentaString("[LIST]"))
 	fmt.Printf("Lists all entries in a specified Keystore or all Keystores\n")
 	fmt.Printf("Usage:\t\tsnowpass list %v\n", color.GreenString("[keystoreName|all]"))
@@ -34,6 +47,11 @@ func DisplayHelp() {
 	fmt.Printf("Usage:\t\tsnowpass copy %v from %v\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
 	fmt.Printf("Example:\tsnowpass copy %v from %v\n\n", color.GreenString("github_token"), color.CyanString("work"))
 
+	fmt.Printf("%v\n", color.MagentaString("[OTP]"))
+	fmt.Printf("Prints the current TOTP/HOTP code for an entry holding an otpauth:// URI or a base32 secret\n")
+	fmt.Printf("Usage:\t\tsnowpass otp %v from %v [--copy]\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass otp %v from %v\n\n", color.GreenString("github_2fa"), color.CyanString("work"))
+
 	fmt.Printf("%v\n", color.GreenString("[EDIT]"))
 	fmt.Printf("Edit or delet the data for an existing identifier in a specified Keystore\n")
 	fmt.Printf("Usage:\t\tsnowpass edit %v in %v\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
@@ -41,8 +59,58 @@ func DisplayHelp() {
 
 	fmt.Printf("%v\n", color.YellowString("[CHANGE-PASSWORD]"))
 	fmt.Printf("Change the password for a specified Keystore\n")
-	fmt.Printf("Usage:\t\tsnowpass change-password %v\n", color.CyanString("[keystore]"))
-	fmt.Printf("Example:\tsnowpass change-password %v\n\n", color.CyanString("work"))
+	fmt.Printf("Usage:\t\tsnowpass change-password %v [--keyfile %v | --remove-keyfile]\n", color.CyanString("[keystore]"), color.GreenString("path"))
+	fmt.Printf("Example:\tsnowpass change-password %v\n", color.CyanString("work"))
+	fmt.Printf("Example:\tsnowpass change-password %v --keyfile %v\t(adds or rotates the keyfile)\n\n", color.CyanString("prod"), color.GreenString("/media/usb/prod-2.key"))
+
+	fmt.Printf("%v\n", color.YellowString("[SLOTS]"))
+	fmt.Printf("Manage the key slots of a Keystore, each of which can unlock it\n")
+	fmt.Printf("Usage:\t\tsnowpass slots list %v\n", color.CyanString("[keystore]"))
+	fmt.Printf("\t\tsnowpass slots add %v [--keyfile %v | --recovery]\n", color.CyanString("[keystore]"), color.GreenString("path"))
+	fmt.Printf("\t\tsnowpass slots remove %v %v\n", color.CyanString("[keystore]"), color.GreenString("[slot]"))
+	fmt.Printf("Example:\tsnowpass slots add %v --recovery\t(prints a new recovery key)\n\n", color.CyanString("work"))
+
+	fmt.Printf("%v\n", color.YellowString("[SPLIT-RECOVERY / RECOVER]"))
+	fmt.Printf("Split a recovery secret into shares (text and QR) so that any M of N unlock the Keystore\n")
+	fmt.Printf("Usage:\t\tsnowpass split-recovery %v --shares %v --threshold %v [--png %v]\n", color.CyanString("[keystore]"), color.GreenString("N"), color.GreenString("M"), color.GreenString("dir"))
+	fmt.Printf("\t\tsnowpass recover %v\t(asks for shares, then a new master password)\n", color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass split-recovery %v --shares %v --threshold %v\n\n", color.CyanString("ops"), color.GreenString("5"), color.GreenString("3"))
+
+	fmt.Printf("%v\n", color.YellowString("[MEMBERS / IDENTITY]"))
+	fmt.Printf("Share a Keystore with people who unlock it with their own age identity\n")
+	fmt.Printf("Usage:\t\tsnowpass identity\t(prints your recipient, creating an identity if needed)\n")
+	fmt.Printf("\t\tsnowpass members list %v\n", color.CyanString("[keystore]"))
+	fmt.Printf("\t\tsnowpass members add %v %v %v\n", color.CyanString("[keystore]"), color.GreenString("[name]"), color.GreenString("[age1...]"))
+	fmt.Printf("\t\tsnowpass members remove %v %v\t(rotates the data key)\n", color.CyanString("[keystore]"), color.GreenString("[name]"))
+	fmt.Printf("Example:\tsnowpass members add %v %v %v\n\n", color.CyanString("ops"), color.GreenString("alice"), color.GreenString("age1gjefrj..."))
+
+	fmt.Printf("%v\n", color.YellowString("[SHARE / RECEIVE]"))
+	fmt.Printf("Sends a single entry as an encrypted bundle, protected by a one-time passphrase or an age recipient\n")
+	fmt.Printf("Usage:\t\tsnowpass share %v from %v [--to age1...] [--expires 7d] [-o file]\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
+	fmt.Printf("\t\tsnowpass receive %v to %v [--as identifier]\t(file, armored text or - for stdin)\n", color.GreenString("[bundle]"), color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass share %v from %v --expires 24h -o %v\n\n", color.GreenString("db_password"), color.CyanString("work"), color.GreenString("db.bundle"))
+
+	fmt.Printf("%v\n", color.MagentaString("[RUN]"))
+	fmt.Printf("Runs a command with entries in its environment, unlocking each Keystore once\n")
+	fmt.Printf("Usage:\t\tsnowpass run --env %v ... -- %v\n", color.GreenString("VAR=keystore/identifier"), color.CyanString("command [args...]"))
+	fmt.Printf("Example:\tsnowpass run --env %v --env %v -- %v\n\n", color.GreenString("DB_PASS=work/db_password"), color.GreenString("GH=work/github_token"), color.CyanString("./deploy.sh"))
+
+	fmt.Printf("%v\n", color.MagentaString("[INJECT]"))
+	fmt.Printf("Renders a template with {{ snowpass \"keystore\" \"identifier\" }} or sp://keystore/identifier references into a 0600 file\n")
+	fmt.Printf("Usage:\t\tsnowpass inject -i %v -o %v [--check]\n", color.GreenString("template"), color.CyanString("output"))
+	fmt.Printf("Example:\tsnowpass inject -i %v -o %v\n\n", color.GreenString("app.conf.tpl"), color.CyanString("app.conf"))
+
+	fmt.Printf("%v\n", color.YellowString("[PROTECT / UNPROTECT]"))
+	fmt.Printf("Marks an entry as sensitive, so it always asks for the master password, optionally with a passphrase of its own\n")
+	fmt.Printf("Usage:\t\tsnowpass protect %v in %v [--passphrase]\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
+	fmt.Printf("\t\tsnowpass unprotect %v in %v\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass protect %v in %v --passphrase\n\n", color.GreenString("prod_root"), color.CyanString("ops"))
+
+	fmt.Printf("%v\n", color.YellowString("[EXPIRY / EXPIRING]"))
+	fmt.Printf("Records when an entry expires or should be rotated, and lists what is due across all Keystores\n")
+	fmt.Printf("Usage:\t\tsnowpass expiry %v in %v [--expires %v|never] [--rotate-every %v|never]\n", color.GreenString("[identifier]"), color.CyanString("[keystore]"), color.GreenString("date|90d"), color.GreenString("90d"))
+	fmt.Printf("\t\tsnowpass expiring [--within %v] [--json] %v\n", color.GreenString("30d"), color.CyanString("[keystore...]"))
+	fmt.Printf("Example:\tsnowpass expiry %v in %v --expires %v\n\n", color.GreenString("github_token"), color.CyanString("work"), color.GreenString("2025-06-30"))
 
 	fmt.Printf("%v\n", color.RedString("[DELETE]"))
 	fmt.Printf("Deletes an identifier and its data from a specified Keystore\n")
@@ -54,6 +122,49 @@ func DisplayHelp() {
 	fmt.Printf("Usage:\t\tsnowpass delete-keystore %v\n", color.CyanString("[keystore]"))
 	fmt.Printf("Example:\tsnowpass delete-keystore %v\n\n", color.CyanString("work"))
 
+	fmt.Printf("%v\n", color.BlueString("[USE]"))
+	fmt.Printf("Sets the default Keystore so the `from [keystore]` part can be left out\n")
+	fmt.Printf("Usage:\t\tsnowpass use %v\n", color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass use %v\n", color.CyanString("work"))
+	fmt.Printf("Example:\tsnowpass get %v\n\n", color.GreenString("github_token"))
+
+	fmt.Printf("%v\n", color.RedString("[BREACH-CHECK]"))
+	fmt.Printf("Checks every entry against a local Have I Been Pwned SHA-1 dataset (file or range directory)\n")
+	fmt.Printf("Usage:\t\tsnowpass breach-check %v [--hibp path]\n", color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass breach-check %v --hibp %v\n\n", color.CyanString("work"), color.GreenString("~/hibp/pwned-passwords-sha1-ordered-by-hash-v8.txt"))
+
+	fmt.Printf("%v\n", color.YellowString("[AUDIT]"))
+	fmt.Printf("Reports reused, weak, stale and empty values and index drift. Exits 1 on findings, 2 on errors\n")
+	fmt.Printf("Usage:\t\tsnowpass audit %v [--json] [--stale-days N]\n", color.CyanString("[keystore...|--all]"))
+	fmt.Printf("Example:\tsnowpass audit %v --stale-days %v\n\n", color.CyanString("--all"), color.GreenString("90"))
+
+	fmt.Printf("%v\n", color.GreenString("[VERIFY / REPAIR]"))
+	fmt.Printf("Finds index mismatches, orphaned or malformed files and undecryptable entries; repair rebuilds indexes and removes orphans\n")
+	fmt.Printf("Usage:\t\tsnowpass verify %v\n", color.CyanString("[keystore...]"))
+	fmt.Printf("Usage:\t\tsnowpass repair %v\n", color.CyanString("[keystore...]"))
+	fmt.Printf("Example:\tsnowpass repair %v\n\n", color.CyanString("work"))
+
+	fmt.Printf("%v\n", color.GreenString("[LOG]"))
+	fmt.Printf("Shows who created, read or changed entries of a Keystore and checks that the log was not altered\n")
+	fmt.Printf("Usage:\t\tsnowpass log %v\n", color.CyanString("[keystore]"))
+	fmt.Printf("Example:\tsnowpass log %v\n\n", color.CyanString("work"))
+
+	fmt.Printf("%v\n", color.GreenString("[FIX-PERMISSIONS]"))
+	fmt.Printf("Makes keystores, the config file and the identity private (files 0600, directories 0700)\n")
+	fmt.Printf("Usage:\t\tsnowpass fix-permissions\n\n")
+
+	fmt.Printf("%v\n", color.MagentaString("[CONFIG]"))
+	fmt.Printf("Reads or changes settings in ~/.config/snowpass/config.toml\n")
+	fmt.Printf("Usage:\t\tsnowpass config %v\n", color.GreenString("get|set|unset|list [key] [value]"))
+	fmt.Printf("Example:\tsnowpass config set %v %v\n", color.GreenString("session_timeout"), color.CyanString("5m"))
+	fmt.Printf("Example:\tsnowpass config set %v %v\n\n", color.GreenString("keystores.work.clipboard_timeout"), color.CyanString("30s"))
+
+	fmt.Printf("%v\n", color.CyanString("[GLOBAL OPTIONS]"))
+	fmt.Printf("Can be given with any command\n")
+	fmt.Printf("--store %v\tUse keystores from another directory (or set SNOWPASS_HOME)\n", color.GreenString("[path]"))
+	fmt.Printf("--profile %v\tUse a separate named profile (or set SNOWPASS_PROFILE)\n", color.GreenString("[name]"))
+	fmt.Printf("Example:\tsnowpass --profile %v list\n\n", color.GreenString("personal"))
+
 	color.Yellow("\n=================== END Usage ===================\n")
 
 }
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+const injectUsage = "Usage for inject: snowpass inject -i template -o output [--check]"
+
+// Inject renders a template, replacing {{ snowpass "keystore" "identifier" }}
+// and sp://keystore/identifier with the values of the entries, and writes it
+// with mode 0600. With --check it only makes sure every reference resolves
+// and writes nothing. It returns one of the Exit* codes.
+func Inject(dataDir string, args []string) int {
+	var input, output string
+	check := false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-i", "--input", "-o", "--output":
+			if i+1 >= len(args) {
+				fmt.Printf("%s needs a path\n", arg)
+				return ExitError
+			}
+			i++
+			if arg == "-i" || arg == "--input" {
+				input = args[i]
+			} else {
+				output = args[i]
+			}
+		case "--check":
+			check = true
+		default:
+			fmt.Printf("Unknown argument %q\n", arg)
+			fmt.Println(injectUsage)
+			return ExitError
+		}
+	}
+	if input == "" || (output == "" && !check) {
+		fmt.Println(injectUsage)
+		return ExitError
+	}
+
+	inputPath, err := utils.ExpandHome(input)
+	if err != nil {
+		fmt.Println("Failed to read template:", err)
+		return ExitError
+	}
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		fmt.Println("Failed to read template:", err)
+		return ExitError
+	}
+	template := string(data)
+
+	refs, problems := utils.FindTemplateRefs(template)
+	entries := make([]entryRef, 0, len(refs))
+	lines := make(map[entryRef][]int)
+	for i, ref := range refs {
+		keystoreName, _, err := KeystorePath(dataDir, ref.Keystore)
+		var identifier string
+		if err == nil {
+			identifier, err = utils.NormalizeIdentifier(ref.Identifier)
+		}
+		if err != nil {
+			problems = append(problems, utils.TemplateError{Line: ref.Line, Text: template[ref.Start:ref.End], Err: err})
+			continue
+		}
+		refs[i].Keystore, refs[i].Identifier = keystoreName, identifier
+		entry := entryRef{keystoreName, identifier}
+		if lines[entry] == nil {
+			entries = append(entries, entry)
+		}
+		lines[entry] = append(lines[entry], ref.Line)
+	}
+	if len(problems) > 0 {
+		color.Red("%s has malformed references:", input)
+		for _, problem := range problems {
+			fmt.Println("   ", problem)
+		}
+		if check {
+			return ExitFindings
+		}
+		return ExitError
+	}
+
+	if check {
+		return checkTemplateRefs(dataDir, input, entries, lines)
+	}
+
+	values, err := resolveEntries(dataDir, entries, logInject)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	rendered := utils.RenderTemplate(template, refs, func(ref utils.TemplateRef) []byte {
+		return values[entryRef{ref.Keystore, ref.Identifier}].Bytes()
+	})
+	destroyValues(values)
+	defer rendered.Destroy()
+
+	outputPath, err := utils.ExpandHome(output)
+	if err == nil {
+		outputPath, err = filepath.Abs(outputPath)
+	}
+	if err == nil {
+		err = utils.WriteFileAtomic(outputPath, rendered.Bytes(), utils.PrivateFileMode)
+	}
+	if err != nil {
+		fmt.Println("Failed to write output:", err)
+		return ExitError
+	}
+	fmt.Printf("Wrote %s with %d references from %d entries.\n", output, len(refs), len(entries))
+	return ExitClean
+}
+
+// checkTemplateRefs unlocks the keystores a template refers to and reports
+// the entries that do not exist, without decrypting any.
+func checkTemplateRefs(dataDir, input string, entries []entryRef, lines map[entryRef][]int) int {
+	var missing, available []entryRef
+	for _, entry := range entries {
+		if keystoreExists(dataDir, entry.Keystore) {
+			available = append(available, entry)
+		} else {
+			missing = append(missing, entry)
+		}
+	}
+	err := unlockEach(dataDir, available, func(keystorePath string, ks *Keystore, password string, identifiers []string) error {
+		keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+		for _, identifier := range identifiers {
+			if _, exists := ks.Passwords[identifier]; !exists {
+				missing = append(missing, entryRef{keystoreName, identifier})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+
+	if len(missing) > 0 {
+		byLine := make(map[int][]string)
+		var missingLines []int
+		for _, entry := range missing {
+			for _, line := range lines[entry] {
+				if byLine[line] == nil {
+					missingLines = append(missingLines, line)
+				}
+				byLine[line] = append(byLine[line], entry.Keystore+"/"+entry.Identifier)
+			}
+		}
+		sort.Ints(missingLines)
+
+		color.Red("%s refers to entries that do not exist:", input)
+		for _, line := range missingLines {
+			fmt.Printf("    line %d: %s\n", line, strings.Join(byLine[line], ", "))
+		}
+		return ExitFindings
+	}
+	color.Green("All %d entries %s refers to exist.", len(entries), input)
+	return ExitClean
+}
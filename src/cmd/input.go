@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+
+	"golang.org/x/term"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// stdin is shared by every prompt. A bufio.Reader reads ahead, so with input
+// piped in a second reader would miss the lines the first one buffered.
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptInput is the file stdin reads from, os.Stdin unless
+// promptsFromTerminal moved the prompts.
+var promptInput = os.Stdin
+
+// promptsFromTerminal makes the prompts read from the controlling terminal,
+// for commands that have used stdin up for data.
+func promptsFromTerminal() error {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return err
+	}
+	promptInput = tty
+	stdin = bufio.NewReader(tty)
+	return nil
+}
+
+// readSecret reads a line without echoing it. When stdin is not a terminal
+// the line comes from the shared reader, which term.ReadPassword would skip.
+func readSecret() ([]byte, error) {
+	if term.IsTerminal(int(promptInput.Fd())) {
+		return term.ReadPassword(int(promptInput.Fd()))
+	}
+	line, err := stdin.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// readLine reads a line from the shared reader, with the line ending.
+func readLine() ([]byte, error) {
+	return stdin.ReadBytes('\n')
+}
+
+// readSecureLine moves a line read by read into a SecureBuffer, without the
+// surrounding whitespace, and wipes what read returned.
+func readSecureLine(read func() ([]byte, error)) (*utils.SecureBuffer, error) {
+	line, err := read()
+	if err != nil {
+		utils.Wipe(line)
+		return nil, err
+	}
+	buf := utils.SecureBytes(bytes.TrimSpace(line))
+	utils.Wipe(line)
+	return buf, nil
+}
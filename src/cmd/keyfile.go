@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// keyfileDigests remembers the keyfiles read during this run by keystore
+// name, so a keystore that is loaded twice only asks once.
+var keyfileDigests = make(map[string][]byte)
+
+// readKeyfile returns the SHA-256 of a keyfile. Like in KeePass any file can
+// be a keyfile, only its exact bytes matter.
+func readKeyfile(path string) ([]byte, error) {
+	path, err := utils.ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Wipe(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("keyfile %s is empty", path)
+	}
+	sum := sha256.Sum256(data)
+	return utils.SecureBytes(sum[:]).Bytes(), nil
+}
+
+// newKeyfile returns the digest of the keyfile at path for a keystore that is
+// about to require it. A random keyfile is written there first if the file
+// does not exist yet.
+func newKeyfile(path string) ([]byte, error) {
+	expanded, err := utils.ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(expanded); os.IsNotExist(err) {
+		data := make([]byte, 64)
+		if _, err := rand.Read(data); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(expanded, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Wrote a new random keyfile to %s. Keep a copy of it, the keystore cannot be opened without it.\n", expanded)
+	}
+
+	return readKeyfile(expanded)
+}
+
+// keyfileFor returns the digest of the keyfile that unlocks keystoreName. The
+// path comes from the keyfile setting, SNOWPASS_KEYFILE or a prompt.
+func keyfileFor(keystoreName string) ([]byte, error) {
+	if digest, ok := keyfileDigests[keystoreName]; ok {
+		return digest, nil
+	}
+
+	path := keyfilePathFor(keystoreName)
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "Keyfile for %s: ", keystoreName)
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		path = strings.TrimSpace(line)
+		if path == "" {
+			return nil, fmt.Errorf("%s needs a keyfile to unlock", keystoreName)
+		}
+	}
+
+	digest, err := readKeyfile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %v", err)
+	}
+	keyfileDigests[keystoreName] = digest
+	return digest, nil
+}
+
+func keyfilePathFor(keystoreName string) string {
+	if path := states.GlobalConfig.ForKeystore(keystoreName).Keyfile; path != "" {
+		return path
+	}
+	return os.Getenv("SNOWPASS_KEYFILE")
+}
+
+// SplitKeyfileOptions separates --keyfile <path> and --remove-keyfile, as
+// taken by create and change-password, from the positional arguments.
+func SplitKeyfileOptions(args []string) (positional []string, keyfilePath string, removeKeyfile bool, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--keyfile":
+			if i+1 >= len(args) {
+				return nil, "", false, fmt.Errorf("--keyfile needs a path")
+			}
+			i++
+			keyfilePath = args[i]
+		case strings.HasPrefix(arg, "--keyfile="):
+			keyfilePath = strings.TrimPrefix(arg, "--keyfile=")
+		case arg == "--remove-keyfile":
+			removeKeyfile = true
+		case strings.HasPrefix(arg, "-"):
+			return nil, "", false, fmt.Errorf("unknown option %q", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if keyfilePath != "" && removeKeyfile {
+		return nil, "", false, fmt.Errorf("--keyfile and --remove-keyfile cannot be used together")
+	}
+	return positional, keyfilePath, removeKeyfile, nil
+}
@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// Members of a shared keystore each have a slot with the data key encrypted
+// to their age X25519 recipient. The slot is a plain age file (base64 after
+// the "age$" prefix), so `age -d` with the member's identity opens it too.
+const memberPrefix = "age$"
+
+// loadOwnIdentities returns the identities of this user, or nil if there is
+// no identity file.
+func loadOwnIdentities() []*age.X25519Identity {
+	path, err := utils.GetIdentityPath()
+	if err != nil {
+		return nil
+	}
+	identities, err := utils.LoadIdentities(path)
+	if err != nil {
+		return nil
+	}
+	return identities
+}
+
+// memberSlotFor returns the member slot that one of the identities can open.
+func memberSlotFor(header models.KeystoreHeader, identities []*age.X25519Identity) (*models.KeySlot, *age.X25519Identity) {
+	for i, slot := range header.Slots {
+		if slot.Type != slotMember {
+			continue
+		}
+		for _, identity := range identities {
+			if identity.Recipient().String() == slot.Recipient {
+				return &header.Slots[i], identity
+			}
+		}
+	}
+	return nil, nil
+}
+
+// hasMemberIdentity reports whether this user can unlock the keystore with
+// their identity, so that no password needs to be asked for.
+func hasMemberIdentity(keystoreID string) bool {
+	file, err := readKeystoreFile(filepath.Join(states.GlobalDataDirectory, keystoreID))
+	if err != nil {
+		return false
+	}
+	slot, _ := memberSlotFor(file.Header, loadOwnIdentities())
+	return slot != nil
+}
+
+func unlockWithIdentity(keystoreName string, header models.KeystoreHeader) (*utils.SecureBuffer, int, bool) {
+	slot, identity := memberSlotFor(header, loadOwnIdentities())
+	if slot == nil {
+		return nil, 0, false
+	}
+	encoded, err := utils.AgeDecrypt(strings.TrimPrefix(slot.Wrapped, memberPrefix), identity)
+	if err != nil {
+		return nil, 0, false
+	}
+	defer encoded.Destroy()
+	dataKey, err := decodeDataKey(encoded.Bytes())
+	if err != nil {
+		return nil, 0, false
+	}
+	return dataKey, slot.ID, true
+}
+
+func wrapDataKeyForMember(ks *Keystore, slot *models.KeySlot) error {
+	recipient, err := utils.ParseRecipient(slot.Recipient)
+	if err != nil {
+		return err
+	}
+	encoded := utils.NewSecureBuffer(hex.EncodedLen(len(ks.DataKey.Bytes())))
+	defer encoded.Destroy()
+	hex.Encode(encoded.Bytes(), ks.DataKey.Bytes())
+	wrapped, err := utils.AgeEncrypt(encoded.Bytes(), recipient)
+	if err != nil {
+		return err
+	}
+	slot.Wrapped = memberPrefix + wrapped
+	return nil
+}
+
+// IdentityCommand prints the age recipient of this user, creating an
+// identity first if there is none. New members send it to whoever adds them.
+func IdentityCommand() {
+	path, err := utils.GetIdentityPath()
+	if err != nil {
+		fmt.Println("Failed to find identity file:", err)
+		return
+	}
+
+	identities, err := utils.LoadIdentities(path)
+	if os.IsNotExist(err) {
+		identity, err := utils.GenerateIdentity(path)
+		if err != nil {
+			fmt.Println("Failed to create identity:", err)
+			return
+		}
+		fmt.Printf("Created a new identity in %s. Keep it secret and back it up.\n", path)
+		identities = []*age.X25519Identity{identity}
+	} else if err != nil {
+		fmt.Println("Failed to read identity:", err)
+		return
+	}
+
+	fmt.Println("Your recipient (share this to be added to keystores):")
+	for _, identity := range identities {
+		fmt.Println(identity.Recipient())
+	}
+}
+
+// MembersCommand handles `snowpass members list|add|remove`.
+func MembersCommand(dataDir string, args []string) {
+	if len(args) < 2 {
+		printMembersUsage()
+		return
+	}
+
+	keystoreName, keystorePath, err := KeystorePath(dataDir, args[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := os.Stat(keystorePath); err != nil {
+		fmt.Printf("Keystore %q does not exist.\n", keystoreName)
+		return
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 2:
+		listMembers(keystorePath)
+	case args[0] == "add" && len(args) == 4:
+		addMember(keystorePath, keystoreName, args[2], args[3])
+	case args[0] == "remove" && len(args) == 3:
+		removeMember(keystorePath, keystoreName, args[2])
+	default:
+		printMembersUsage()
+	}
+}
+
+func printMembersUsage() {
+	fmt.Println("Usage for members:")
+	fmt.Println("  snowpass members list [keystore]")
+	fmt.Println("  snowpass members add [keystore] [name] [age1... recipient]")
+	fmt.Println("  snowpass members remove [keystore] [name]")
+}
+
+func listMembers(keystorePath string) {
+	file, err := readKeystoreFile(keystorePath)
+	if err != nil {
+		fmt.Println("Failed to read keystore:", err)
+		return
+	}
+	for _, slot := range file.Header.Slots {
+		if slot.Type == slotMember {
+			fmt.Printf("%s\t%s\n", slot.Member, slot.Recipient)
+		}
+	}
+}
+
+func findMember(ks *Keystore, name string) int {
+	for i, slot := range ks.Header.Slots {
+		if slot.Type == slotMember && slot.Member == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func addMember(keystorePath, keystoreName, name, recipient string) {
+	if _, err := utils.ParseRecipient(recipient); err != nil {
+		fmt.Println("Invalid recipient:", err)
+		return
+	}
+
+	ks, password, ok := unlockForSlots(keystorePath)
+	if !ok {
+		return
+	}
+	if findMember(ks, name) >= 0 {
+		fmt.Printf("%s is already a member of %s.\n", name, keystoreName)
+		return
+	}
+
+	slot := models.KeySlot{
+		ID:        nextSlotID(ks),
+		Type:      slotMember,
+		Created:   time.Now().UTC(),
+		Member:    name,
+		Recipient: strings.TrimSpace(recipient),
+	}
+	if err := wrapDataKeyForMember(ks, &slot); err != nil {
+		fmt.Println("Failed to add member:", err)
+		return
+	}
+	ks.Header.Slots = append(ks.Header.Slots, slot)
+	logWrite(keystorePath, ks, logMemberAdd, name)
+	saveKeystore(keystorePath, ks, password)
+	fmt.Printf("Added %s to %s (slot %d).\n", name, keystoreName, slot.ID)
+}
+
+// slotSecret is what a slot is wrapped with. The secret has to be
+// destroyed.
+type slotSecret struct {
+	secret  *utils.SecureBuffer
+	keyfile []byte
+}
+
+// reachSlot finds the secret of a slot other than a member's, so that it can
+// follow a new data key: the secret the keystore was unlocked with, or one
+// that is asked for. Slots of split secrets are only opened by `recover`.
+func reachSlot(ks *Keystore, keystoreName string, slot models.KeySlot, password string) (slotSecret, bool) {
+	if slot.Type == slotShares {
+		return slotSecret{}, false
+	}
+	var keyfile []byte
+	if slot.Keyfile {
+		var err error
+		if keyfile = ks.Keyfile; keyfile == nil {
+			if keyfile, err = keyfileFor(keystoreName); err != nil {
+				return slotSecret{}, false
+			}
+		}
+	}
+	opens := func(secret string) bool {
+		dataKey, err := unwrapDataKey(keystoreName, slot, secret, keyfile)
+		if err != nil {
+			return false
+		}
+		defer dataKey.Destroy()
+		return subtle.ConstantTimeCompare(dataKey.Bytes(), ks.DataKey.Bytes()) == 1
+	}
+	keep := func(secret string) slotSecret {
+		if slot.Type == slotRecovery {
+			return slotSecret{utils.NormalizeSecureRecoveryKey(secret), keyfile}
+		}
+		return slotSecret{utils.SecureBytes([]byte(secret)), keyfile}
+	}
+
+	if password != "" && opens(password) {
+		return keep(password), true
+	}
+	fmt.Fprintf(os.Stderr, "Secret of slot %d (%s) to keep it, or Enter to skip: ", slot.ID, slot.Type)
+	secret, err := readSecureLine(readSecret)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return slotSecret{}, false
+	}
+	defer secret.Destroy()
+	if len(secret.Bytes()) == 0 {
+		return slotSecret{}, false
+	}
+	if !opens(secret.String()) {
+		fmt.Printf("That does not open slot %d.\n", slot.ID)
+		return slotSecret{}, false
+	}
+	return keep(secret.String()), true
+}
+
+// removeMember drops a member's slot and rotates the data key, the access
+// log key and the identity failed unlocks are recorded for, so that what the
+// former member kept opens nothing written from now on and cannot add to the
+// access log. Every other slot follows the new key, with the secret used to
+// unlock or with its own secret, asked for; slots that cannot follow are
+// listed and only removed after asking.
+func removeMember(keystorePath, keystoreName, name string) {
+	ks, password, ok := unlockForSlots(keystorePath)
+	if !ok {
+		return
+	}
+	removed := findMember(ks, name)
+	if removed < 0 {
+		fmt.Printf("%s is not a member of %s.\n", name, keystoreName)
+		return
+	}
+	removedID := ks.Header.Slots[removed].ID
+	if ks.LogKey != "" {
+		if _, problems := verifyAccessLog(keystorePath, ks); len(problems) > 0 {
+			color.Red("The access log of %s has been tampered with, see `snowpass log %s`. Nothing was changed.", keystoreName, keystoreName)
+			return
+		}
+	}
+
+	secrets := make(map[int]slotSecret)
+	defer func() {
+		for _, secret := range secrets {
+			secret.secret.Destroy()
+		}
+	}()
+	var kept, unreachable []models.KeySlot
+	for _, slot := range ks.Header.Slots {
+		switch {
+		case slot.ID == removedID:
+		case slot.Type == slotMember:
+			kept = append(kept, slot)
+		default:
+			if secret, ok := reachSlot(ks, keystoreName, slot, password); ok {
+				secrets[slot.ID] = secret
+				kept = append(kept, slot)
+			} else {
+				unreachable = append(unreachable, slot)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		fmt.Println("Refusing to remove the member, nothing would be left to unlock the keystore with.")
+		return
+	}
+	if len(unreachable) > 0 {
+		color.Yellow("The data key is rotated, and these slots cannot follow it without their secret:")
+		for _, slot := range unreachable {
+			fmt.Printf("    %d\t%s\n", slot.ID, slot.Type)
+		}
+		fmt.Print("Remove them? They can be added again with `snowpass slots add` or `split-recovery`. [y/N]: ")
+		answer, err := stdin.ReadString('\n')
+		if err != nil || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+			fmt.Println("Nothing was changed.")
+			return
+		}
+	}
+
+	if err := rotateDataKey(ks, keystoreName, password, kept, secrets); err != nil {
+		fmt.Println("Failed to rotate the data key:", err)
+		return
+	}
+	if err := resealAccessLog(keystorePath, ks); err != nil {
+		fmt.Println("Failed to rotate the access log key:", err)
+		return
+	}
+	logWrite(keystorePath, ks, logMemberRemove, name)
+	for _, slot := range unreachable {
+		logWrite(keystorePath, ks, logSlotRemove, slotLogName(slot))
+	}
+	// saveKeystore creates a new one
+	ks.AttemptsIdentity = ""
+	saveKeystore(keystorePath, ks, password)
+	fmt.Printf("Removed %s from %s and rotated the data key.\n", name, keystoreName)
+	color.Yellow("%s could read every entry until now; change the ones that matter.", name)
+}
+
+// rotateDataKey re-encrypts every entry under a new data key and wraps it in
+// the given slots. Member slots only need the recipient, the others are
+// wrapped with their secret.
+func rotateDataKey(ks *Keystore, keystoreName, password string, slots []models.KeySlot, secrets map[int]slotSecret) error {
+	entries, err := decryptEntries(ks, keystoreName, password)
+	if err != nil {
+		return err
+	}
+	defer destroyEntries(entries)
+
+	oldKey := ks.DataKey
+	dataKey, err := newDataKey()
+	if err != nil {
+		return err
+	}
+	ks.DataKey = dataKey
+	for id, data := range entries {
+		encryptedData, err := encryptEntry(ks, data.Bytes(), password, keystoreName, id)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", id, err)
+		}
+		ks.Passwords[id] = encryptedData
+	}
+	oldKey.Destroy()
+
+	for i := range slots {
+		slot := &slots[i]
+		var err error
+		if slot.Type == slotMember {
+			err = wrapDataKeyForMember(ks, slot)
+		} else {
+			secret := secrets[slot.ID]
+			err = wrapDataKey(ks, keystoreName, slot, secret.secret.String(), secret.keyfile)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	ks.Header.Slots = slots
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// ShowOTP prints (or copies) the current one-time code for an entry that
+// holds an otpauth:// URI or a base32 secret. For HOTP entries the counter is
+// advanced and written back to the keystore.
+func ShowOTP(keystorePath, identifier string, copyValue bool) {
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+
+	if _, exists := ks.Passwords[identifier]; !exists {
+		fmt.Println("Identifier not found.")
+		return
+	}
+
+	value, passphrase, err := openEntry(keystorePath, ks, password, identifier)
+	if err != nil {
+		fmt.Println("Failed to decrypt data:", err)
+		return
+	}
+	defer value.Destroy()
+
+	params, err := utils.ParseOTPSecret(value.String())
+	if err != nil {
+		fmt.Printf("%s does not hold an OTP secret: %v\n", identifier, err)
+		return
+	}
+
+	var code string
+	var remaining int
+	if params.Type == "hotp" {
+		code, err = utils.HOTP(params.Secret, params.Counter, params.Digits, params.Algorithm)
+		if err != nil {
+			fmt.Println("Failed to generate code:", err)
+			return
+		}
+
+		// persist the next counter before showing the code so that a code is
+		// never handed out twice
+		params.Counter++
+		newEncryptedData, err := sealEntry(ks, []byte(params.URI()), password, keystoreNameFromID(keystoreID), identifier, passphrase)
+		if err != nil {
+			fmt.Println("Failed to encrypt updated counter:", err)
+			return
+		}
+		ks.Passwords[identifier] = newEncryptedData
+		if saveKeystore(keystorePath, ks, password) != nil {
+			// the counter may be handed out again, so the code is not shown
+			return
+		}
+	} else {
+		code, remaining, err = utils.TOTP(params.Secret, time.Now(), params.Period, params.Digits, params.Algorithm)
+		if err != nil {
+			fmt.Println("Failed to generate code:", err)
+			return
+		}
+	}
+
+	if copyValue {
+		if !copyToClipboard([]byte(code)) {
+			return
+		}
+	} else {
+		fmt.Println(code)
+	}
+
+	if params.Type == "hotp" {
+		fmt.Printf("Counter: %d\n", params.Counter-1)
+	} else {
+		fmt.Printf("Valid for %ds\n", remaining)
+	}
+	logRead(keystorePath, ks, logOTP, identifier)
+	storeKeystorePassword(keystoreID, password)
+}
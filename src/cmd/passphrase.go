@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// PassphraseCommand prints (or copies) a diceware passphrase.
+// Usage: snowpass passphrase [words] [--separator S] [--capitalize] [--digit] [--copy]
+func PassphraseCommand(args []string) {
+	opts := utils.DefaultPassphraseOptions()
+	copyValue := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--words", "--separator":
+			if i+1 >= len(args) {
+				fmt.Printf("%s needs a value\n", arg)
+				return
+			}
+			i++
+			if arg == "--separator" {
+				opts.Separator = args[i]
+				continue
+			}
+			arg = args[i]
+			fallthrough
+		default:
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Printf("Unknown option %q\n", arg)
+				return
+			}
+			opts.Words = n
+		case "--capitalize":
+			opts.Capitalize = true
+		case "--digit":
+			opts.AppendDigit = true
+		case "--copy":
+			copyValue = true
+		}
+	}
+
+	passphrase, entropy, err := utils.GeneratePassphrase(opts)
+	if err != nil {
+		fmt.Println("Failed to generate passphrase:", err)
+		return
+	}
+
+	if copyValue {
+		copyToClipboard([]byte(passphrase))
+	} else {
+		fmt.Println(passphrase)
+	}
+	fmt.Printf("Entropy: %.1f bits\n", entropy)
+}
+
+// offerPassphrase asks whether a new keystore should get a generated master
+// passphrase. It returns the passphrase once the user confirms they have
+// written it down.
+func offerPassphrase() (string, bool) {
+	fmt.Print("Generate a random passphrase as the master password? [y/N]: ")
+	answer, err := stdin.ReadString('\n')
+	if err != nil || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+		return "", false
+	}
+
+	passphrase, entropy, err := utils.GeneratePassphrase(utils.DefaultPassphraseOptions())
+	if err != nil {
+		fmt.Println("Failed to generate passphrase:", err)
+		return "", false
+	}
+
+	fmt.Println("Your master passphrase is:")
+	fmt.Println()
+	fmt.Println("    " + color.GreenString(passphrase))
+	fmt.Println()
+	fmt.Printf("(%.1f bits of entropy) Write it down now, it will not be shown again.\n", entropy)
+	fmt.Print("Press Enter once you have saved it: ")
+	if _, err := stdin.ReadString('\n'); err != nil {
+		return "", false
+	}
+
+	return passphrase, true
+}
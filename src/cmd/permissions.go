@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// CheckDataPermissions warns about keystores, the config file or the
+// identity that other users can get at. With `permissions = "refuse"` it
+// reports false and snowpass stops before touching any secret.
+func CheckDataPermissions(dataDir string) bool {
+	setting := states.GlobalConfig.Permissions
+	if setting == "ignore" {
+		return true
+	}
+
+	problems := utils.CheckPermissions(utils.PrivatePaths(dataDir))
+	if len(problems) == 0 {
+		return true
+	}
+
+	// on stderr, so that it stays out of --json and other piped output
+	color.New(color.FgYellow).Fprintln(os.Stderr, "Warning: these files can be read or changed by other users:")
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, "   ", problem)
+	}
+	fmt.Fprintln(os.Stderr, "Run `snowpass fix-permissions` to make them private.")
+
+	if setting == "refuse" {
+		color.New(color.FgRed).Fprintln(os.Stderr, "Refusing to continue, see the permissions setting.")
+		return false
+	}
+	return true
+}
+
+// FixPermissionsCommand restricts the data directory, the config file and the
+// identity to the current user.
+func FixPermissionsCommand(dataDir string) {
+	problems := utils.CheckPermissions(utils.PrivatePaths(dataDir))
+	if len(problems) == 0 {
+		fmt.Println("All files are private already.")
+		return
+	}
+
+	remaining := utils.FixPermissions(problems)
+	fixed := len(problems) - len(remaining)
+	if fixed > 0 {
+		color.Green("Made %d files and directories private.", fixed)
+	}
+	if len(remaining) > 0 {
+		color.Red("Could not fix:")
+		for _, problem := range remaining {
+			fmt.Println("   ", problem)
+		}
+		fmt.Println("Files owned by another user have to be moved or chowned by hand.")
+	}
+}
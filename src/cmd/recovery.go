@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// SplitRecovery adds a key slot whose secret is split into shares, any
+// threshold of which unlock the keystore through `snowpass recover`. The
+// shares are printed as text and QR codes, and optionally written as PNGs.
+func SplitRecovery(dataDir string, args []string) {
+	shares, threshold := 0, 0
+	pngDir := ""
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--shares", "--threshold", "--png":
+			if i+1 >= len(args) {
+				fmt.Printf("%s needs a value\n", args[i])
+				return
+			}
+			flag := args[i]
+			i++
+			if flag == "--png" {
+				pngDir = args[i]
+				continue
+			}
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				fmt.Printf("%s must be a number\n", flag)
+				return
+			}
+			if flag == "--shares" {
+				shares = n
+			} else {
+				threshold = n
+			}
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	if len(positional) != 1 || shares == 0 || threshold == 0 {
+		fmt.Println("Usage for split-recovery: snowpass split-recovery [keystore] --shares N --threshold M [--png dir]")
+		return
+	}
+	if threshold < 2 || threshold > shares || shares > 255 {
+		fmt.Println("The threshold must be at least 2 and at most the number of shares (255 at most).")
+		return
+	}
+
+	keystoreName, keystorePath, err := KeystorePath(dataDir, positional[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := os.Stat(keystorePath); err != nil {
+		fmt.Printf("Keystore %q does not exist.\n", keystoreName)
+		return
+	}
+
+	ks, password, ok := unlockForSlots(keystorePath)
+	if !ok {
+		return
+	}
+
+	secretBuf, err := utils.RandomSecureBuffer(32)
+	if err != nil {
+		fmt.Println("Failed to generate secret:", err)
+		return
+	}
+	defer secretBuf.Destroy()
+	secret := secretBuf.Bytes()
+	set := make([]byte, 4)
+	if _, err := rand.Read(set); err != nil {
+		fmt.Println("Failed to generate secret:", err)
+		return
+	}
+
+	parts, err := utils.SplitSecret(secret, shares, threshold)
+	if err != nil {
+		fmt.Println("Failed to split secret:", err)
+		return
+	}
+
+	slot := models.KeySlot{
+		ID:        nextSlotID(ks),
+		Type:      slotShares,
+		Created:   time.Now().UTC(),
+		ShareSet:  hex.EncodeToString(set),
+		Threshold: threshold,
+		Shares:    shares,
+	}
+	encoded := hexSecret(secret)
+	defer encoded.Destroy()
+	if err := wrapDataKey(ks, keystoreName, &slot, encoded.String(), nil); err != nil {
+		fmt.Println("Failed to add slot:", err)
+		return
+	}
+	ks.Header.Slots = append(ks.Header.Slots, slot)
+	logWrite(keystorePath, ks, logSlotAdd, slotLogName(slot))
+	saveKeystore(keystorePath, ks, password)
+
+	fmt.Printf("Split a new recovery secret for %s into %d shares, any %d of which unlock it (slot %d).\n", keystoreName, shares, threshold, slot.ID)
+	fmt.Println("Hand each share to a different person. They will not be shown again.")
+	for i, part := range parts {
+		text := utils.EncodeShare(utils.Share{Set: set, Threshold: threshold, Data: part})
+
+		fmt.Println()
+		color.Yellow("Share %d of %d", i+1, shares)
+		fmt.Println(text)
+		if code, err := utils.RenderQR(text); err == nil {
+			fmt.Print(code)
+		} else {
+			fmt.Println("Failed to render QR code:", err)
+		}
+
+		if pngDir != "" {
+			pngPath := filepath.Join(pngDir, fmt.Sprintf("%s-share-%d.png", keystoreName, i+1))
+			png, err := utils.QRPNG(text)
+			if err == nil {
+				err = os.WriteFile(pngPath, png, 0600)
+			}
+			if err != nil {
+				fmt.Println("Failed to write QR code:", err)
+				continue
+			}
+			fmt.Println("Written to", pngPath)
+		}
+	}
+}
+
+// Recover unlocks a keystore with enough shares from `split-recovery` and
+// sets a new master password.
+func Recover(dataDir string, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage for recover: snowpass recover [keystore]")
+		return
+	}
+
+	keystoreName, keystorePath, err := KeystorePath(dataDir, args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+
+	file, err := readKeystoreFile(keystorePath)
+	if err != nil {
+		fmt.Println("Failed to read keystore:", err)
+		return
+	}
+
+	var slot *models.KeySlot
+	var set []byte
+	var parts [][]byte
+	for slot == nil || len(parts) < slot.Threshold {
+		fmt.Printf("Share %d: ", len(parts)+1)
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			fmt.Println("Failed to read share:", err)
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		share, err := utils.DecodeShare(line)
+		if err != nil {
+			color.Red("%v", err)
+			continue
+		}
+		if slot == nil {
+			slot = findShareSlot(file.Header, share.Set)
+			if slot == nil {
+				color.Red("This share does not belong to any key slot of %s.", keystoreName)
+				continue
+			}
+			set = share.Set
+		} else if !bytes.Equal(share.Set, set) {
+			color.Red("This share belongs to a different split.")
+			continue
+		}
+		duplicate := false
+		for _, part := range parts {
+			duplicate = duplicate || part[0] == share.Data[0]
+		}
+		if duplicate {
+			color.Red("This share was already entered.")
+			continue
+		}
+		parts = append(parts, share.Data)
+	}
+
+	secret, err := utils.CombineShares(parts)
+	if err != nil {
+		fmt.Println("Failed to combine shares:", err)
+		return
+	}
+	defer utils.Wipe(secret)
+	encoded := hexSecret(secret)
+	dataKey, err := unwrapDataKey(keystoreName, *slot, encoded.String(), nil)
+	encoded.Destroy()
+	if err != nil {
+		fmt.Println("The shares do not unlock the keystore, one of them may be wrong.")
+		return
+	}
+	ks, err := openSlottedKeystore(file, keystoreName, dataKey, slot.ID, nil)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+	color.Green("Unlocked %s.", keystoreName)
+
+	fmt.Println("Choose a new master password.")
+	setBypassSessionCheck(true) // Force a new password prompt
+	newPassword, err := promptForPassword(true, keystoreID)
+	setBypassSessionCheck(false)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+	if !checkPasswordStrength(newPassword, currentConfig().Strength.MinScore, "strength.min_score", keystoreName) {
+		return
+	}
+
+	passwordSlot := passwordSlotToChange(ks)
+	if passwordSlot.Keyfile {
+		color.Yellow("Slot %d no longer needs a keyfile, add one again with `snowpass change-password %s --keyfile path`.", passwordSlot.ID, keystoreName)
+	}
+	if err := wrapDataKey(ks, keystoreName, passwordSlot, newPassword, nil); err != nil {
+		fmt.Println("Failed to set the new password:", err)
+		return
+	}
+
+	logWrite(keystorePath, ks, logChangePassword, "")
+	saveKeystore(keystorePath, ks, newPassword)
+	storeKeystorePassword(keystoreID, newPassword)
+	fmt.Println("Master password set. The shares keep working until the slot is removed.")
+}
+
+func findShareSlot(header models.KeystoreHeader, set []byte) *models.KeySlot {
+	for i, slot := range header.Slots {
+		if slot.Type == slotShares && slot.ShareSet == hex.EncodeToString(set) {
+			return &header.Slots[i]
+		}
+	}
+	return nil
+}
+
+// hexSecret hex encodes the secret of a split slot, which is what the data
+// key is wrapped with, into a SecureBuffer.
+func hexSecret(secret []byte) *utils.SecureBuffer {
+	encoded := utils.NewSecureBuffer(hex.EncodedLen(len(secret)))
+	hex.Encode(encoded.Bytes(), secret)
+	return encoded
+}
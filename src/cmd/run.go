@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+const runUsage = "Usage for run: snowpass run --env VAR=keystore/identifier ... -- command [args...]"
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// entryRef names an entry of a keystore.
+type entryRef struct {
+	Keystore   string
+	Identifier string
+}
+
+// envReference is an entry to put into a variable, given as VAR=keystore/id
+// or VAR=id for the default keystore.
+type envReference struct {
+	Name string
+	entryRef
+}
+
+func parseEnvReference(dataDir, spec string) (envReference, error) {
+	name, ref, found := strings.Cut(spec, "=")
+	if !found || !envNamePattern.MatchString(name) {
+		return envReference{}, fmt.Errorf("--env needs VAR=keystore/identifier, got %q", spec)
+	}
+	// keystore names cannot contain a slash, identifiers can
+	keystoreName, identifier, found := strings.Cut(ref, "/")
+	if !found {
+		keystoreName, identifier = states.GlobalConfig.DefaultKeystore, ref
+		if keystoreName == "" {
+			return envReference{}, fmt.Errorf("%s: no keystore given and no default keystore set", name)
+		}
+	}
+	keystoreName, _, err := KeystorePath(dataDir, keystoreName)
+	if err != nil {
+		return envReference{}, fmt.Errorf("%s: %v", name, err)
+	}
+	identifier, err = utils.NormalizeIdentifier(identifier)
+	if err != nil {
+		return envReference{}, fmt.Errorf("%s: %v", name, err)
+	}
+	return envReference{Name: name, entryRef: entryRef{Keystore: keystoreName, Identifier: identifier}}, nil
+}
+
+// unlockEach unlocks every keystore the references name once, in order of
+// name, and calls fn with it and the identifiers wanted from it.
+func unlockEach(dataDir string, refs []entryRef, fn func(keystorePath string, ks *Keystore, password string, identifiers []string) error) error {
+	byKeystore := make(map[string][]string)
+	for _, ref := range refs {
+		byKeystore[ref.Keystore] = append(byKeystore[ref.Keystore], ref.Identifier)
+	}
+	keystores := make([]string, 0, len(byKeystore))
+	for keystoreName := range byKeystore {
+		keystores = append(keystores, keystoreName)
+	}
+	sort.Strings(keystores)
+
+	for _, keystoreName := range keystores {
+		keystorePath := filepath.Join(dataDir, keystoreName+".json")
+		if _, err := os.Stat(keystorePath); err != nil {
+			return fmt.Errorf("keystore %q does not exist", keystoreName)
+		}
+
+		keystoreID := filepath.Base(keystorePath)
+		if len(keystores) > 1 {
+			fmt.Fprintf(os.Stderr, "Unlocking %s\n", keystoreName)
+		}
+		password, err := promptForPassword(false, keystoreID)
+		if err != nil {
+			return fmt.Errorf("%s: failed to read password: %v", keystoreName, err)
+		}
+		ks, err := loadKeystore(keystorePath, password)
+		if err != nil {
+			return fmt.Errorf("%s: failed to load keystore: %v", keystoreName, err)
+		}
+		if err := fn(keystorePath, ks, password, byKeystore[keystoreName]); err != nil {
+			return err
+		}
+		storeKeystorePassword(keystoreID, password)
+	}
+	return nil
+}
+
+// resolveEntries decrypts the entries refs name, unlocking each keystore
+// once, and logs each read as operation. The values have to be destroyed
+// with destroyValues.
+func resolveEntries(dataDir string, refs []entryRef, operation string) (map[entryRef]*utils.SecureBuffer, error) {
+	values := make(map[entryRef]*utils.SecureBuffer, len(refs))
+	err := unlockEach(dataDir, refs, func(keystorePath string, ks *Keystore, password string, identifiers []string) error {
+		keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+		for _, identifier := range identifiers {
+			ref := entryRef{keystoreName, identifier}
+			if _, ok := values[ref]; ok {
+				continue
+			}
+			if _, exists := ks.Passwords[identifier]; !exists {
+				return fmt.Errorf("%s/%s: identifier not found", keystoreName, identifier)
+			}
+			data, _, err := openEntry(keystorePath, ks, password, identifier)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %v", keystoreName, identifier, err)
+			}
+			warnIfPastDue(ks, keystoreName, identifier)
+			values[ref] = data
+			logRead(keystorePath, ks, operation, identifier)
+		}
+		return nil
+	})
+	if err != nil {
+		destroyValues(values)
+		return nil, err
+	}
+	return values, nil
+}
+
+func destroyValues(values map[entryRef]*utils.SecureBuffer) {
+	for _, data := range values {
+		data.Destroy()
+	}
+}
+
+// Run starts a command with entries in its environment, as in
+// `snowpass run --env DB_PASS=work/db_password -- ./deploy.sh`. Every
+// keystore is unlocked once, the values only ever exist in memory and in
+// the environment of the child, and the exit code of the child is returned.
+func Run(dataDir string, args []string) int {
+	var refs []envReference
+	var command []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			command = args[i+1:]
+			i = len(args)
+		case arg == "--env" || arg == "-e":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "--env needs VAR=keystore/identifier")
+				return ExitError
+			}
+			i++
+			ref, err := parseEnvReference(dataDir, args[i])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return ExitError
+			}
+			refs = append(refs, ref)
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown argument %q\n", arg)
+			fmt.Fprintln(os.Stderr, runUsage)
+			return ExitError
+		}
+	}
+	if len(refs) == 0 || len(command) == 0 {
+		fmt.Fprintln(os.Stderr, runUsage)
+		return ExitError
+	}
+
+	entries := make([]entryRef, len(refs))
+	for i, ref := range refs {
+		entries[i] = ref.entryRef
+	}
+	values, err := resolveEntries(dataDir, entries, logRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	env, variables := childEnvironment(os.Environ(), refs, values)
+	child.Env = env
+	destroyValues(values)
+
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	err = child.Start()
+	// Start has copied the environment for the child, which leaves the
+	// strings in env pointing at the buffers about to be destroyed
+	child.Env = nil
+	for _, variable := range variables {
+		variable.Destroy()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start %s: %v\n", command[0], err)
+		// what a shell returns for a command it cannot run
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return 127
+		}
+		return 126
+	}
+	go func() {
+		for sig := range signals {
+			if !deliveredByTerminal(sig) {
+				child.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if err := child.Wait(); child.ProcessState == nil {
+		fmt.Fprintf(os.Stderr, "Failed to wait for %s: %v\n", command[0], err)
+		return ExitError
+	}
+	return exitStatus(child.ProcessState)
+}
+
+// childEnvironment is env with the variables of refs set to their values,
+// replacing variables of the same name. The last --env for a name wins.
+func childEnvironment(env []string, refs []envReference, values map[entryRef]*utils.SecureBuffer) ([]string, []*utils.SecureBuffer) {
+	last := make(map[string]int, len(refs))
+	for i, ref := range refs {
+		last[ref.Name] = i
+	}
+	result := make([]string, 0, len(env)+len(refs))
+	for _, variable := range env {
+		name, _, _ := strings.Cut(variable, "=")
+		if _, replaced := last[name]; !replaced {
+			result = append(result, variable)
+		}
+	}
+	var variables []*utils.SecureBuffer
+	for i, ref := range refs {
+		if last[ref.Name] == i {
+			value := values[ref.entryRef].Bytes()
+			variable := utils.NewSecureBuffer(len(ref.Name) + 1 + len(value))
+			n := copy(variable.Bytes(), ref.Name)
+			variable.Bytes()[n] = '='
+			copy(variable.Bytes()[n+1:], value)
+			variables = append(variables, variable)
+			result = append(result, variable.String())
+		}
+	}
+	return result, variables
+}
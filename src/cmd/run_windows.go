@@ -0,0 +1,15 @@
+package cmd
+
+import "os"
+
+// Windows only has interrupts, which the console delivers to the child
+// itself.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+func deliveredByTerminal(sig os.Signal) bool {
+	return true
+}
+
+func exitStatus(state *os.ProcessState) int {
+	return state.ExitCode()
+}
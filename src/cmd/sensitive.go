@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// freshPassword is set when promptForPassword read the password from the
+// terminal rather than from the session, so that a sensitive entry does not
+// ask for a password that was typed a moment ago.
+var freshPassword bool
+
+// errWrongPassphrase means the passphrase of an entry does not open it.
+var errWrongPassphrase = errors.New("wrong passphrase")
+
+// An entry with a passphrase of its own is encrypted with it first, in the
+// format of encrypt, and the result is encrypted with the keystore like any
+// other value. Unlocking the keystore therefore is not enough to read it.
+func entryPassphraseAD(keystoreName, identifier string) []byte {
+	return []byte(fmt.Sprintf("snowpass/entry-passphrase/%d:%s/%d:%s", len(keystoreName), keystoreName, len(identifier), identifier))
+}
+
+func entryMeta(ks *Keystore, identifier string) models.EntryMeta {
+	return ks.Entries[identifier]
+}
+
+func setEntryMeta(ks *Keystore, identifier string, meta models.EntryMeta) {
+	if meta == (models.EntryMeta{}) {
+		delete(ks.Entries, identifier)
+		return
+	}
+	if ks.Entries == nil {
+		ks.Entries = make(map[string]models.EntryMeta)
+	}
+	ks.Entries[identifier] = meta
+}
+
+// authorizeEntry asks for the master password again before a sensitive
+// entry is read or changed, unless it was just typed. A recovery key is
+// accepted as well, an age identity or a session is not.
+func authorizeEntry(keystorePath string, ks *Keystore, identifier string) error {
+	if !entryMeta(ks, identifier).Sensitive || freshPassword {
+		return nil
+	}
+
+	keystoreID := filepath.Base(keystorePath)
+	fmt.Fprintf(os.Stderr, "%s is sensitive, confirm the master password.\n", identifier)
+	setBypassSessionCheck(true) // Force a new password prompt
+	password, err := promptForPassword(false, keystoreID)
+	setBypassSessionCheck(false)
+	if err != nil {
+		return err
+	}
+
+	if len(ks.Header.Slots) == 0 {
+		// loadKeystore records a wrong password itself
+		_, err := loadKeystore(keystorePath, password)
+		return err
+	}
+
+	waitForUnlockDelay(keystorePath)
+	dataKey, _, _, err := unlockSlots(keystoreNameFromID(keystoreID), ks.Header, password)
+	if errors.Is(err, errWrongPassword) {
+		recordFailedUnlock(keystorePath, ks.Header)
+	}
+	if err != nil {
+		return err
+	}
+	dataKey.Destroy()
+	reportFailedUnlocks(keystorePath, ks)
+	return nil
+}
+
+func promptForEntryPassphrase(identifier string, verify bool) (string, error) {
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", identifier)
+	passphrase, err := readSecureLine(readSecret)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if verify {
+		fmt.Fprint(os.Stderr, "Verify passphrase: ")
+		verifyPassphrase, err := readSecureLine(readSecret)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			passphrase.Destroy()
+			return "", err
+		}
+		matches := subtle.ConstantTimeCompare(passphrase.Bytes(), verifyPassphrase.Bytes()) == 1
+		verifyPassphrase.Destroy()
+		if !matches {
+			passphrase.Destroy()
+			return "", fmt.Errorf("passphrases do not match")
+		}
+		if len(passphrase.Bytes()) == 0 {
+			return "", fmt.Errorf("the passphrase must not be empty")
+		}
+	}
+	// like the master password it is wiped by DestroySecrets on exit
+	return passphrase.String(), nil
+}
+
+// openEntry decrypts an entry for a command that hands out or changes its
+// value, after confirming the master password for sensitive entries and
+// asking for the passphrase of the entry if it has one. The passphrase is
+// returned so that a changed value can be encrypted with it again.
+func openEntry(keystorePath string, ks *Keystore, password, identifier string) (*utils.SecureBuffer, string, error) {
+	encryptedData, exists := ks.Passwords[identifier]
+	if !exists {
+		return nil, "", fmt.Errorf("identifier not found")
+	}
+	if err := authorizeEntry(keystorePath, ks, identifier); err != nil {
+		return nil, "", err
+	}
+
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	data, err := decryptEntry(ks, encryptedData, password, keystoreName, identifier)
+	if err != nil || !entryMeta(ks, identifier).Passphrase {
+		return data, "", err
+	}
+	defer data.Destroy()
+
+	passphrase, err := promptForEntryPassphrase(identifier, false)
+	if err != nil {
+		return nil, "", err
+	}
+	value, err := decrypt(string(data.Bytes()), passphrase, nil, entryPassphraseAD(keystoreName, identifier))
+	if errors.Is(err, errAuthentication) {
+		return nil, "", errWrongPassphrase
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return value, passphrase, nil
+}
+
+// sealEntry is encryptEntry for a value that may have a passphrase of its
+// own.
+func sealEntry(ks *Keystore, data []byte, password, keystoreName, identifier, passphrase string) (string, error) {
+	if passphrase != "" {
+		inner, err := encrypt(data, passphrase, nil, entryPassphraseAD(keystoreName, identifier))
+		if err != nil {
+			return "", err
+		}
+		data = []byte(inner)
+	}
+	return encryptEntry(ks, data, password, keystoreName, identifier)
+}
+
+// ProtectEntry marks an entry as sensitive. With a passphrase the value is
+// also encrypted with a passphrase of its own, or with a new one if it
+// already had one.
+func ProtectEntry(keystorePath, identifier string, passphrase bool) {
+	changeProtection(keystorePath, identifier, true, passphrase)
+}
+
+// UnprotectEntry removes the sensitive mark and the passphrase of an entry.
+func UnprotectEntry(keystorePath, identifier string) {
+	changeProtection(keystorePath, identifier, false, false)
+}
+
+func changeProtection(keystorePath, identifier string, sensitive, passphrase bool) {
+	keystoreID := filepath.Base(keystorePath)
+	keystoreName := keystoreNameFromID(keystoreID)
+	setCurrentKeystoreID(keystoreID)
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+	if _, exists := ks.Passwords[identifier]; !exists {
+		fmt.Println("Identifier not found.")
+		return
+	}
+
+	data, oldPassphrase, err := openEntry(keystorePath, ks, password, identifier)
+	if err != nil {
+		fmt.Println("Failed to decrypt data:", err)
+		return
+	}
+	defer data.Destroy()
+
+	newPassphrase := ""
+	switch {
+	case passphrase:
+		fmt.Printf("Choose a new passphrase for %s.\n", identifier)
+		newPassphrase, err = promptForEntryPassphrase(identifier, true)
+		if err != nil {
+			fmt.Println("Failed to read passphrase:", err)
+			return
+		}
+	case sensitive:
+		// protecting again keeps the passphrase
+		newPassphrase = oldPassphrase
+	}
+
+	encryptedData, err := sealEntry(ks, data.Bytes(), password, keystoreName, identifier, newPassphrase)
+	if err != nil {
+		fmt.Println("Failed to encrypt data:", err)
+		return
+	}
+	ks.Passwords[identifier] = encryptedData
+
+	meta := entryMeta(ks, identifier)
+	meta.Sensitive = sensitive
+	meta.Passphrase = newPassphrase != ""
+	setEntryMeta(ks, identifier, meta)
+
+	operation := logProtect
+	if !sensitive {
+		operation = logUnprotect
+	}
+	logWrite(keystorePath, ks, operation, identifier)
+	saveKeystore(keystorePath, ks, password)
+	storeKeystorePassword(keystoreID, password)
+
+	switch {
+	case !sensitive:
+		fmt.Printf("%s is no longer sensitive.\n", identifier)
+	case meta.Passphrase:
+		fmt.Printf("%s is sensitive and has a passphrase of its own.\n", identifier)
+	default:
+		fmt.Printf("%s is sensitive.\n", identifier)
+	}
+}
@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// ShareOptions are the options of `snowpass share`.
+type ShareOptions struct {
+	Recipient string
+	Expires   time.Duration
+	Output    string
+}
+
+// SplitShareOptions separates the options of `share` from the identifier
+// and keystore arguments.
+func SplitShareOptions(args []string) (positional []string, opts ShareOptions, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--to", "--expires", "-o", "--output":
+			if i+1 >= len(args) {
+				return nil, opts, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			switch arg {
+			case "--to":
+				opts.Recipient = args[i]
+			case "--expires":
+				opts.Expires, err = utils.ParseLongDuration(args[i])
+				if err != nil || opts.Expires == 0 {
+					return nil, opts, fmt.Errorf("--expires needs a duration such as 24h or 7d")
+				}
+			default:
+				opts.Output = args[i]
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return nil, opts, fmt.Errorf("unknown option %q", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+	return positional, opts, nil
+}
+
+// SplitReceiveOptions separates --as from the bundle and keystore arguments.
+// A lone "-" reads the bundle from standard input and is kept positional.
+func SplitReceiveOptions(args []string) (positional []string, as string, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--as":
+			if i+1 >= len(args) {
+				return nil, "", fmt.Errorf("--as needs an identifier")
+			}
+			i++
+			as = args[i]
+		case arg != "-" && strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "-----BEGIN"):
+			return nil, "", fmt.Errorf("unknown option %q", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	return positional, as, nil
+}
+
+// ShareEntry writes a single entry as a bundle that can be imported into
+// another keystore with `snowpass receive`. Without a recipient the bundle is
+// protected by a one-time passphrase, which is printed separately and should
+// be sent through another channel.
+func ShareEntry(keystorePath, identifier string, opts ShareOptions) {
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+
+	var recipient age.Recipient
+	protection, recipientName, passphrase := utils.BundleProtectionPassphrase, "", ""
+	if opts.Recipient != "" {
+		x25519, err := utils.ParseRecipient(opts.Recipient)
+		if err != nil {
+			fmt.Println("Invalid recipient:", err)
+			return
+		}
+		recipient = x25519
+		protection, recipientName = utils.BundleProtectionRecipient, x25519.String()
+	} else {
+		var err error
+		passphrase, _, err = utils.GeneratePassphrase(utils.DefaultPassphraseOptions())
+		if err == nil {
+			recipient, err = age.NewScryptRecipient(passphrase)
+		}
+		if err != nil {
+			fmt.Println("Failed to generate passphrase:", err)
+			return
+		}
+	}
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+
+	if _, exists := ks.Passwords[identifier]; !exists {
+		fmt.Println("Identifier not found.")
+		return
+	}
+
+	value, _, err := openEntry(keystorePath, ks, password, identifier)
+	if err != nil {
+		fmt.Println("Failed to decrypt data:", err)
+		return
+	}
+	defer value.Destroy()
+	logRead(keystorePath, ks, logShare, identifier)
+	storeKeystorePassword(keystoreID, password)
+
+	entry := utils.BundleEntry{
+		Identifier: identifier,
+		Value:      value,
+		Created:    time.Now().UTC(),
+	}
+	if opts.Expires > 0 {
+		expires := entry.Created.Add(opts.Expires)
+		entry.Expires = &expires
+	}
+
+	bundle, err := utils.SealBundle(entry, protection, recipient, recipientName)
+	if err != nil {
+		fmt.Println("Failed to create bundle:", err)
+		return
+	}
+	armored, err := bundle.Armor()
+	if err != nil {
+		fmt.Println("Failed to create bundle:", err)
+		return
+	}
+
+	if opts.Output != "" {
+		outputPath, err := utils.ExpandHome(opts.Output)
+		if err == nil {
+			err = os.WriteFile(outputPath, []byte(armored), 0600)
+		}
+		if err != nil {
+			fmt.Println("Failed to write bundle:", err)
+			return
+		}
+		fmt.Printf("Bundle for %s written to %s.\n", identifier, outputPath)
+	} else {
+		fmt.Print(armored)
+	}
+
+	if entry.Expires != nil {
+		fmt.Printf("It can be received until %s.\n", entry.Expires.Local().Format("2006-01-02 15:04"))
+	}
+	if passphrase != "" {
+		color.Yellow("One-time passphrase (send it through a different channel than the bundle):")
+		fmt.Println(passphrase)
+	}
+}
+
+// ReceiveBundle imports the entry of a bundle from `snowpass share` into a
+// keystore, under its original identifier unless as is given.
+func ReceiveBundle(keystorePath, source, keystoreName, as string) {
+	keystoreID := filepath.Base(keystorePath)
+	setCurrentKeystoreID(keystoreID)
+
+	if _, err := os.Stat(keystorePath); err != nil {
+		fmt.Printf("Keystore %q does not exist.\n", keystoreName)
+		return
+	}
+
+	bundle, err := utils.ReadBundle(source, stdin)
+	if err != nil {
+		fmt.Println("Failed to read bundle:", err)
+		return
+	}
+	if source == "-" {
+		// the bundle took stdin to its end, so the passphrase and the
+		// master password have to come from somewhere else
+		if err := promptsFromTerminal(); err != nil {
+			fmt.Println("Cannot ask for the passphrase or master password, stdin held the bundle and there is no terminal:", err)
+			fmt.Println("Save the bundle to a file and receive that instead.")
+			return
+		}
+	}
+	if bundle.Expires != nil && time.Now().After(*bundle.Expires) {
+		fmt.Printf("The bundle expired on %s.\n", bundle.Expires.Local().Format("2006-01-02 15:04"))
+		return
+	}
+
+	var identities []age.Identity
+	switch bundle.Protection {
+	case utils.BundleProtectionPassphrase:
+		fmt.Print("Bundle passphrase: ")
+		passphrase, err := readSecureLine(readSecret)
+		fmt.Println()
+		if err != nil {
+			fmt.Println("Failed to read passphrase:", err)
+			return
+		}
+		// the identity keeps its own copy, so the passphrase is only
+		// needed until the bundle is opened
+		defer passphrase.Destroy()
+		identity, err := age.NewScryptIdentity(passphrase.String())
+		if err != nil {
+			fmt.Println("Failed to read passphrase:", err)
+			return
+		}
+		identities = append(identities, identity)
+	case utils.BundleProtectionRecipient:
+		for _, identity := range loadOwnIdentities() {
+			identities = append(identities, identity)
+		}
+		if len(identities) == 0 {
+			fmt.Println("The bundle is encrypted to a recipient, but you have no identity. See `snowpass identity`.")
+			return
+		}
+	default:
+		fmt.Printf("Unsupported bundle protection %q.\n", bundle.Protection)
+		return
+	}
+
+	var entry *utils.BundleEntry
+	for _, identity := range identities {
+		if entry, err = utils.OpenBundle(bundle, identity); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		if bundle.Protection == utils.BundleProtectionPassphrase {
+			fmt.Println("Failed to open bundle, the passphrase may be wrong:", err)
+		} else {
+			fmt.Println("Failed to open bundle, it may be meant for someone else:", err)
+		}
+		return
+	}
+	defer entry.Value.Destroy()
+
+	identifier := entry.Identifier
+	if as != "" {
+		identifier = as
+	}
+	identifier, err = utils.NormalizeIdentifier(identifier)
+	if err != nil {
+		fmt.Println("Cannot receive the entry:", err)
+		return
+	}
+
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return
+	}
+	if _, exists := ks.Passwords[identifier]; exists {
+		fmt.Printf("%s already exists in %s. Use --as to receive it under another identifier.\n", identifier, keystoreName)
+		return
+	}
+
+	if storeEntry(keystorePath, ks, identifier, keystoreName, password, entry.Value.Bytes()) {
+		storeKeystorePassword(keystoreID, password)
+		fmt.Printf("Received %s into %s.\n", identifier, keystoreName)
+	}
+}
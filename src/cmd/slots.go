@@ -0,0 +1,464 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/models"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// Keystores are encrypted with a random data key, and every key slot holds a
+// copy of that key wrapped with one secret, much like LUKS. Changing or
+// adding a secret only rewrites its slot.
+const (
+	slotPassword = "password"
+	slotRecovery = "recovery"
+	slotShares   = "shares"
+	slotMember   = "age"
+)
+
+// dataKeyPrefix marks data sealed directly with the data key. It is always
+// bound to its associated data.
+const dataKeyPrefix = boundPrefix + "key$"
+
+func sealWithKey(key, data, ad []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aesGCM.Seal(nonce, nonce, data, ad)
+	return dataKeyPrefix + hex.EncodeToString(sealed), nil
+}
+
+// openWithKey opens data sealed by sealWithKey.
+func openWithKey(key []byte, encryptedData string, ad []byte) (*utils.SecureBuffer, error) {
+	if !strings.HasPrefix(encryptedData, dataKeyPrefix) {
+		return nil, fmt.Errorf("data is not encrypted with the data key")
+	}
+	sealed, err := hex.DecodeString(strings.TrimPrefix(encryptedData, dataKeyPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aesGCM.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	opened, err := aesGCM.Open(nil, sealed[:nonceSize], sealed[nonceSize:], ad)
+	if err != nil {
+		return nil, errAuthentication
+	}
+	return utils.SecureBytes(opened), nil
+}
+
+func slotAD(keystoreName string, slotID int) []byte {
+	return []byte(fmt.Sprintf("snowpass/slot/%d:%s/%d", len(keystoreName), keystoreName, slotID))
+}
+
+// wrapDataKey stores the data key of ks in slot, encrypted with secret and
+// the keyfile digest if there is one.
+func wrapDataKey(ks *Keystore, keystoreName string, slot *models.KeySlot, secret string, keyfile []byte) error {
+	encoded := utils.NewSecureBuffer(hex.EncodedLen(len(ks.DataKey.Bytes())))
+	defer encoded.Destroy()
+	hex.Encode(encoded.Bytes(), ks.DataKey.Bytes())
+	wrapped, err := encrypt(encoded.Bytes(), secret, keyfile, slotAD(keystoreName, slot.ID))
+	if err != nil {
+		return err
+	}
+	slot.Keyfile = keyfile != nil
+	slot.Wrapped = wrapped
+	return nil
+}
+
+func unwrapDataKey(keystoreName string, slot models.KeySlot, secret string, keyfile []byte) (*utils.SecureBuffer, error) {
+	if slot.Type == slotRecovery {
+		normalized := utils.NormalizeSecureRecoveryKey(secret)
+		defer normalized.Destroy()
+		secret = normalized.String()
+	}
+	encoded, err := decrypt(slot.Wrapped, secret, keyfile, slotAD(keystoreName, slot.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer encoded.Destroy()
+	return decodeDataKey(encoded.Bytes())
+}
+
+// decodeDataKey decodes a hex encoded data key into locked memory.
+func decodeDataKey(encoded []byte) (*utils.SecureBuffer, error) {
+	dataKey := utils.NewSecureBuffer(hex.DecodedLen(len(encoded)))
+	if _, err := hex.Decode(dataKey.Bytes(), encoded); err != nil {
+		dataKey.Destroy()
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// newDataKey returns a random data key in locked memory.
+func newDataKey() (*utils.SecureBuffer, error) {
+	return utils.RandomSecureBuffer(32)
+}
+
+// unlockSlots tries secret against every slot and returns the data key, the
+// slot that opened it and the keyfile digest used, if any. Slots without a
+// keyfile are tried first so that a keyfile is only asked for when needed.
+func unlockSlots(keystoreName string, header models.KeystoreHeader, secret string) (*utils.SecureBuffer, int, []byte, error) {
+	for _, needsKeyfile := range []bool{false, true} {
+		for _, slot := range header.Slots {
+			// split secrets are only ever entered through `recover`, and
+			// members unlock with their identity instead of a password
+			if slot.Keyfile != needsKeyfile || slot.Type == slotShares || slot.Type == slotMember {
+				continue
+			}
+			var keyfile []byte
+			if slot.Keyfile {
+				var err error
+				keyfile, err = keyfileFor(keystoreName)
+				if err != nil {
+					return nil, 0, nil, err
+				}
+			}
+			if key, err := unwrapDataKey(keystoreName, slot, secret, keyfile); err == nil {
+				return key, slot.ID, keyfile, nil
+			}
+		}
+	}
+	return nil, 0, nil, errWrongPassword
+}
+
+// initKeySlots moves a keystore that is encrypted with its master password
+// over to a random data key with a single password slot. Its entries are
+// re-encrypted under the data key on the way.
+func initKeySlots(ks *Keystore, keystoreName, password string) error {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return err
+	}
+
+	entries, err := decryptEntries(ks, keystoreName, password)
+	if err != nil {
+		dataKey.Destroy()
+		return err
+	}
+	defer destroyEntries(entries)
+
+	// entries are only replaced once all of them are encrypted again
+	passwords := make(map[string]string, len(entries))
+	ks.DataKey = dataKey
+	for id, data := range entries {
+		encryptedData, err := encryptEntry(ks, data.Bytes(), password, keystoreName, id)
+		if err != nil {
+			ks.DataKey = nil
+			dataKey.Destroy()
+			return fmt.Errorf("failed to encrypt %s: %v", id, err)
+		}
+		passwords[id] = encryptedData
+	}
+
+	slot := models.KeySlot{ID: 0, Type: slotPassword, Created: time.Now().UTC()}
+	if err := wrapDataKey(ks, keystoreName, &slot, password, ks.Keyfile); err != nil {
+		ks.DataKey = nil
+		dataKey.Destroy()
+		return err
+	}
+	ks.Passwords = passwords
+	ks.Header.Slots = []models.KeySlot{slot}
+	ks.UnlockedSlot = slot.ID
+	return nil
+}
+
+// decryptEntries opens every entry of ks, for re-encrypting them under a new
+// key. The values have to be destroyed with destroyEntries.
+func decryptEntries(ks *Keystore, keystoreName, password string) (map[string]*utils.SecureBuffer, error) {
+	entries := make(map[string]*utils.SecureBuffer, len(ks.Passwords))
+	for id, encryptedData := range ks.Passwords {
+		data, err := decryptEntry(ks, encryptedData, password, keystoreName, id)
+		if err != nil {
+			destroyEntries(entries)
+			return nil, fmt.Errorf("failed to decrypt %s: %v", id, err)
+		}
+		entries[id] = data
+	}
+	return entries, nil
+}
+
+func destroyEntries(entries map[string]*utils.SecureBuffer) {
+	for _, data := range entries {
+		data.Destroy()
+	}
+}
+
+func nextSlotID(ks *Keystore) int {
+	next := 0
+	for _, slot := range ks.Header.Slots {
+		if slot.ID >= next {
+			next = slot.ID + 1
+		}
+	}
+	return next
+}
+
+func findSlot(ks *Keystore, id int) int {
+	for i, slot := range ks.Header.Slots {
+		if slot.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// addRecoverySlot adds a slot for a freshly generated recovery key and shows
+// the key, which is not stored anywhere else.
+func addRecoverySlot(ks *Keystore, keystoreName string) bool {
+	recoveryKey, err := utils.GenerateRecoveryKey()
+	if err != nil {
+		fmt.Println("Failed to generate recovery key:", err)
+		return false
+	}
+
+	slot := models.KeySlot{ID: nextSlotID(ks), Type: slotRecovery, Created: time.Now().UTC()}
+	if err := wrapDataKey(ks, keystoreName, &slot, utils.NormalizeRecoveryKey(recoveryKey), nil); err != nil {
+		fmt.Println("Failed to add recovery key:", err)
+		return false
+	}
+	ks.Header.Slots = append(ks.Header.Slots, slot)
+
+	fmt.Printf("Recovery key for %s (slot %d):\n", keystoreName, slot.ID)
+	fmt.Println()
+	fmt.Println("    " + color.GreenString(recoveryKey))
+	fmt.Println()
+	fmt.Println("It unlocks the keystore in place of the master password. Print it or write it")
+	fmt.Println("down and keep it somewhere safe, it will not be shown again.")
+	return true
+}
+
+// offerRecoveryKey asks whether a recovery key should be added at create
+// time.
+func offerRecoveryKey(ks *Keystore, keystoreName string) {
+	fmt.Print("Add a recovery key in case the master password is forgotten? [Y/n]: ")
+	answer, err := stdin.ReadString('\n')
+	if err != nil || strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "n") {
+		return
+	}
+	addRecoverySlot(ks, keystoreName)
+}
+
+// SlotsCommand handles `snowpass slots list|add|remove`.
+func SlotsCommand(dataDir string, args []string) {
+	if len(args) < 2 {
+		printSlotsUsage()
+		return
+	}
+
+	keystoreName, keystorePath, err := KeystorePath(dataDir, args[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := os.Stat(keystorePath); err != nil {
+		fmt.Printf("Keystore %q does not exist.\n", keystoreName)
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		listSlots(keystorePath, keystoreName)
+	case "add":
+		recovery := false
+		keyfilePath := ""
+		for i := 2; i < len(args); i++ {
+			switch {
+			case args[i] == "--recovery":
+				recovery = true
+			case args[i] == "--keyfile" && i+1 < len(args):
+				i++
+				keyfilePath = args[i]
+			case strings.HasPrefix(args[i], "--keyfile="):
+				keyfilePath = strings.TrimPrefix(args[i], "--keyfile=")
+			default:
+				fmt.Printf("unknown option %q\n", args[i])
+				return
+			}
+		}
+		if recovery && keyfilePath != "" {
+			fmt.Println("Recovery keys do not use a keyfile.")
+			return
+		}
+		addSlot(keystorePath, keystoreName, recovery, keyfilePath)
+	case "remove":
+		if len(args) != 3 {
+			printSlotsUsage()
+			return
+		}
+		id, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Printf("Invalid slot %q, see `snowpass slots list %s`.\n", args[2], keystoreName)
+			return
+		}
+		removeSlot(keystorePath, keystoreName, id)
+	default:
+		printSlotsUsage()
+	}
+}
+
+func printSlotsUsage() {
+	fmt.Println("Usage for slots:")
+	fmt.Println("  snowpass slots list [keystore]")
+	fmt.Println("  snowpass slots add [keystore] [--keyfile path | --recovery]")
+	fmt.Println("  snowpass slots remove [keystore] [slot]")
+}
+
+// listSlots only reads the header, so it works without unlocking.
+func listSlots(keystorePath, keystoreName string) {
+	file, err := readKeystoreFile(keystorePath)
+	if err != nil {
+		fmt.Println("Failed to read keystore:", err)
+		return
+	}
+	if len(file.Header.Slots) == 0 {
+		fmt.Printf("%s is encrypted directly with its master password. It gets key slots the next time it is written, e.g. by `snowpass slots add %s`.\n", keystoreName, keystoreName)
+		return
+	}
+
+	for _, slot := range file.Header.Slots {
+		kind := slot.Type
+		if slot.Keyfile {
+			kind += " + keyfile"
+		}
+		switch slot.Type {
+		case slotShares:
+			kind = fmt.Sprintf("shares (%d of %d)", slot.Threshold, slot.Shares)
+		case slotMember:
+			kind = "member " + slot.Member
+		}
+		fmt.Printf("%d\t%-20s\tadded %s\n", slot.ID, kind, slot.Created.Local().Format("2006-01-02 15:04"))
+	}
+}
+
+func unlockForSlots(keystorePath string) (*Keystore, string, bool) {
+	keystoreID := filepath.Base(keystorePath)
+	password, err := promptForPassword(false, keystoreID)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return nil, "", false
+	}
+	ks, err := loadKeystore(keystorePath, password)
+	if err != nil {
+		fmt.Println("Failed to load keystore:", err)
+		return nil, "", false
+	}
+	if ks.DataKey == nil {
+		if err := initKeySlots(ks, keystoreNameFromID(keystoreID), password); err != nil {
+			fmt.Println("Failed to set up key slots:", err)
+			return nil, "", false
+		}
+	}
+	return ks, password, true
+}
+
+func addSlot(keystorePath, keystoreName string, recovery bool, keyfilePath string) {
+	ks, password, ok := unlockForSlots(keystorePath)
+	if !ok {
+		return
+	}
+
+	if recovery {
+		if !addRecoverySlot(ks, keystoreName) {
+			return
+		}
+		logWrite(keystorePath, ks, logSlotAdd, slotLogName(ks.Header.Slots[len(ks.Header.Slots)-1]))
+		saveKeystore(keystorePath, ks, password)
+		return
+	}
+
+	keystoreID := filepath.Base(keystorePath)
+	fmt.Println("Enter the password for the new slot.")
+	setBypassSessionCheck(true) // Force a new password prompt
+	newPassword, err := promptForPassword(true, keystoreID)
+	setBypassSessionCheck(false)
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+	if !checkPasswordStrength(newPassword, currentConfig().Strength.MinScore, "strength.min_score", keystoreName) {
+		return
+	}
+
+	var keyfile []byte
+	if keyfilePath != "" {
+		keyfile, err = newKeyfile(keyfilePath)
+		if err != nil {
+			fmt.Println("Failed to read keyfile:", err)
+			return
+		}
+	}
+
+	slot := models.KeySlot{ID: nextSlotID(ks), Type: slotPassword, Created: time.Now().UTC()}
+	if err := wrapDataKey(ks, keystoreName, &slot, newPassword, keyfile); err != nil {
+		fmt.Println("Failed to add slot:", err)
+		return
+	}
+	ks.Header.Slots = append(ks.Header.Slots, slot)
+	logWrite(keystorePath, ks, logSlotAdd, slotLogName(slot))
+	saveKeystore(keystorePath, ks, password)
+	fmt.Printf("Added password slot %d to %s.\n", slot.ID, keystoreName)
+}
+
+func removeSlot(keystorePath, keystoreName string, id int) {
+	ks, password, ok := unlockForSlots(keystorePath)
+	if !ok {
+		return
+	}
+
+	i := findSlot(ks, id)
+	if i < 0 {
+		fmt.Printf("%s has no slot %d.\n", keystoreName, id)
+		return
+	}
+	if len(ks.Header.Slots) == 1 {
+		fmt.Println("Refusing to remove the last slot, the keystore could never be opened again.")
+		return
+	}
+
+	removed := ks.Header.Slots[i]
+	ks.Header.Slots = append(ks.Header.Slots[:i], ks.Header.Slots[i+1:]...)
+	logWrite(keystorePath, ks, logSlotRemove, slotLogName(removed))
+	saveKeystore(keystorePath, ks, password)
+	if id == ks.UnlockedSlot {
+		forgetKeystorePassword(filepath.Base(keystorePath))
+		color.Yellow("Removed slot %d, which was used to unlock just now. Use another slot from now on.", id)
+		return
+	}
+	fmt.Printf("Removed slot %d from %s.\n", id, keystoreName)
+}
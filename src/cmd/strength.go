@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// checkPasswordStrength prints a strength estimate for value and reports
+// whether it reaches minScore. settingName is the config key to mention when
+// the value is refused.
+func checkPasswordStrength(value string, minScore int, settingName string, userInputs ...string) bool {
+	if value == "" {
+		color.Red("Refusing an empty value.")
+		return false
+	}
+
+	report := utils.EstimateStrength(value, userInputs)
+	line := fmt.Sprintf("Strength: %s (%d/4), estimated time to crack: %s", report.Label, report.Score, report.CrackTime)
+	switch {
+	case report.Score >= 3:
+		color.Green(line)
+	case report.Score >= 2:
+		color.Yellow(line)
+	default:
+		color.Red(line)
+	}
+	if len(report.Findings) > 0 && report.Score < 3 {
+		fmt.Println("Contains " + strings.Join(report.Findings, ", "))
+	}
+
+	if report.Score < minScore {
+		color.Red("Refusing: the minimum score is %d (%s).", minScore, settingName)
+		return false
+	}
+	return true
+}
+
+// checkEntryStrength is checkPasswordStrength for the value of an entry. An
+// empty value is accepted unless strength.min_entry_score asks for more.
+func checkEntryStrength(value, keystoreName, identifier string) bool {
+	minScore := currentConfig().Strength.MinEntryScore
+	if value == "" && minScore == 0 {
+		return true
+	}
+	return checkPasswordStrength(value, minScore, "strength.min_entry_score", keystoreName, identifier)
+}
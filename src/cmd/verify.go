@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/fluffysnowman/snowpass/states"
+	"github.com/fluffysnowman/snowpass/utils"
+)
+
+// dataDirScan is what can be learned about a data directory without any
+// passwords.
+type dataDirScan struct {
+	keystores         []string
+	orphanIndexes     []string // index files without a keystore
+	orphanSideFiles   []string // attempts, access log and expiry files without a keystore
+	missingIndexes    []string // keystores without an index file
+	malformedIndexes  map[string]error
+	malformedFiles    map[string]error
+	malformedAttempts map[string]error
+}
+
+// sideFileSuffixes are the files kept next to a keystore besides its index.
+var sideFileSuffixes = []string{".attempts", ".log", ".expiry"}
+
+func scanDataDir(dataDir string) (*dataDirScan, error) {
+	files, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := &dataDirScan{
+		keystores:         keystoreNames(files),
+		malformedIndexes:  make(map[string]error),
+		malformedFiles:    make(map[string]error),
+		malformedAttempts: make(map[string]error),
+	}
+
+	isKeystore := make(map[string]bool)
+	for _, name := range scan.keystores {
+		isKeystore[name] = true
+
+		file, err := readKeystoreFile(filepath.Join(dataDir, name+".json"))
+		if err == nil {
+			err = checkEncryptedFormat(file.Data)
+		}
+		if err == nil {
+			for _, slot := range file.Header.Slots {
+				if err = checkEncryptedFormat(slot.Wrapped); err != nil {
+					err = fmt.Errorf("key slot %d: %v", slot.ID, err)
+					break
+				}
+			}
+		}
+		if err != nil {
+			scan.malformedFiles[name] = err
+		}
+
+		if _, err := os.Stat(getIndexFilePath(name)); os.IsNotExist(err) {
+			scan.missingIndexes = append(scan.missingIndexes, name)
+		} else if _, err := readKeystoreIndex(name); err != nil {
+			scan.malformedIndexes[name] = err
+		}
+
+		// checked before unlocking, which removes the attempts file
+		if _, err := readAttempts(filepath.Join(dataDir, name+".json")); err != nil && !os.IsNotExist(err) {
+			scan.malformedAttempts[name] = err
+		}
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), "_index.json") {
+			name := strings.TrimSuffix(file.Name(), "_index.json")
+			if !isKeystore[name] {
+				scan.orphanIndexes = append(scan.orphanIndexes, file.Name())
+			}
+			continue
+		}
+		for _, suffix := range sideFileSuffixes {
+			if strings.HasSuffix(file.Name(), suffix) && !isKeystore[strings.TrimSuffix(file.Name(), suffix)] {
+				scan.orphanSideFiles = append(scan.orphanSideFiles, file.Name())
+			}
+		}
+	}
+
+	return scan, nil
+}
+
+// checkEncryptedFormat checks the shape of data written by encrypt without
+// decrypting it.
+func checkEncryptedFormat(encryptedData string) error {
+	encryptedData = strings.TrimSpace(encryptedData)
+	if strings.HasPrefix(encryptedData, memberPrefix) {
+		if _, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encryptedData, memberPrefix)); err != nil {
+			return fmt.Errorf("invalid base64 data: %v", err)
+		}
+		return nil
+	}
+	if strings.HasPrefix(encryptedData, dataKeyPrefix) {
+		if _, err := hex.DecodeString(strings.TrimPrefix(encryptedData, dataKeyPrefix)); err != nil {
+			return fmt.Errorf("invalid hex data: %v", err)
+		}
+		return nil
+	}
+	encryptedData = strings.TrimPrefix(encryptedData, boundPrefix)
+	_, encryptedData, err := splitKDFParams(encryptedData)
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(encryptedData, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid encrypted data format")
+	}
+	for _, part := range parts {
+		if _, err := hex.DecodeString(part); err != nil {
+			return fmt.Errorf("invalid hex data: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkSideFiles checks the access log and the expiry index of an unlocked
+// keystore against it. The attempts file is checked by scanDataDir.
+func checkSideFiles(keystorePath string, ks *Keystore) []string {
+	var problems []string
+
+	if ks.LogKey == "" {
+		if _, err := os.Stat(accessLogPath(keystorePath)); err == nil {
+			problems = append(problems, "there is an access log, but the keystore has no key for it")
+		}
+	} else {
+		_, logProblems := verifyAccessLog(keystorePath, ks)
+		for _, problem := range logProblems {
+			problems = append(problems, "access log: "+problem)
+		}
+	}
+
+	keystoreName := keystoreNameFromID(filepath.Base(keystorePath))
+	data, err := os.ReadFile(expiryIndexPath(keystorePath))
+	switch {
+	case !states.GlobalConfig.ForKeystore(keystoreName).ExpiryIndex:
+		if err == nil {
+			problems = append(problems, "there is an expiry index, but expiry_index is off")
+		}
+	case os.IsNotExist(err):
+		problems = append(problems, "the expiry index is missing")
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("the expiry index cannot be read: %v", err))
+	default:
+		var indexed map[string]entrySchedule
+		if err := json.Unmarshal(data, &indexed); err != nil {
+			problems = append(problems, fmt.Sprintf("malformed expiry index: %v", err))
+			break
+		}
+		got, _ := json.Marshal(indexed)
+		want, _ := json.Marshal(entrySchedules(ks))
+		if string(got) != string(want) {
+			problems = append(problems, "the expiry index does not match the keystore")
+		}
+	}
+	return problems
+}
+
+// Verify checks the data directory for orphaned or malformed files and
+// unlocks the selected keystores (all by default) to compare them with their
+// indexes and make sure every entry decrypts. It returns one of the Exit*
+// codes.
+func Verify(dataDir string, args []string) int {
+	scan, err := scanDataDir(dataDir)
+	if err != nil {
+		fmt.Println("Failed to read user data directory:", err)
+		return ExitError
+	}
+
+	names, err := normalizeKeystoreNames(args)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if len(names) == 0 {
+		names = scan.keystores
+	}
+
+	problems := 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		color.Red("✗ "+format, a...)
+	}
+
+	for _, orphan := range scan.orphanIndexes {
+		report("orphaned index file %s", orphan)
+	}
+	for _, orphan := range scan.orphanSideFiles {
+		report("%s belongs to no keystore", orphan)
+	}
+
+	for _, name := range names {
+		fmt.Printf("Checking %s\n", name)
+		if err, ok := scan.malformedFiles[name]; ok {
+			report("%s: malformed keystore file: %v", name, err)
+			continue
+		}
+		if err, ok := scan.malformedIndexes[name]; ok {
+			report("%s: malformed index file: %v", name, err)
+		}
+		for _, missing := range scan.missingIndexes {
+			if missing == name {
+				report("%s: index file is missing", name)
+			}
+		}
+		if err, ok := scan.malformedAttempts[name]; ok {
+			report("%s: malformed attempts file: %v", name, err)
+		}
+
+		keystorePath := filepath.Join(dataDir, name+".json")
+		if _, err := os.Stat(keystorePath); err != nil {
+			report("%s: %v", name, err)
+			continue
+		}
+
+		keystoreID := filepath.Base(keystorePath)
+		setCurrentKeystoreID(keystoreID)
+		password, err := promptForPassword(false, keystoreID)
+		if err != nil {
+			report("%s: failed to read password: %v", name, err)
+			continue
+		}
+		ks, err := loadKeystore(keystorePath, password)
+		if err != nil {
+			report("%s: failed to unlock: %v", name, err)
+			continue
+		}
+		storeKeystorePassword(keystoreID, password)
+
+		for _, id := range sortedIdentifiers(ks) {
+			value, err := decryptEntry(ks, ks.Passwords[id], password, name, id)
+			if err != nil {
+				report("%s/%s: entry does not decrypt: %v", name, id, err)
+				continue
+			}
+			value.Destroy()
+		}
+		for _, problem := range checkSideFiles(keystorePath, ks) {
+			report("%s: %s", name, problem)
+		}
+
+		if _, malformed := scan.malformedIndexes[name]; !malformed {
+			if drift, ok := indexDrift(name, ks); ok {
+				if len(drift.MissingInIndex) > 0 {
+					report("%s: missing from index: %s", name, strings.Join(drift.MissingInIndex, ", "))
+				}
+				if len(drift.OnlyInIndex) > 0 {
+					report("%s: in index but not in keystore: %s", name, strings.Join(drift.OnlyInIndex, ", "))
+				}
+			}
+		}
+	}
+
+	if problems > 0 {
+		fmt.Printf("\n%d problems found. Run `snowpass repair` to rebuild indexes and expiry indexes and remove orphans.\n", problems)
+		return ExitFindings
+	}
+	color.Green("Everything looks fine.")
+	return ExitClean
+}
+
+// Repair removes orphaned index files and rebuilds the index of the selected
+// keystores (all by default) from their decrypted contents.
+func Repair(dataDir string, args []string) int {
+	scan, err := scanDataDir(dataDir)
+	if err != nil {
+		fmt.Println("Failed to read user data directory:", err)
+		return ExitError
+	}
+
+	failed := false
+	for _, orphan := range scan.orphanIndexes {
+		if err := os.Remove(filepath.Join(dataDir, orphan)); err != nil {
+			fmt.Printf("Failed to remove orphaned index %s: %v\n", orphan, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Removed orphaned index %s\n", orphan)
+	}
+	for _, orphan := range scan.orphanSideFiles {
+		if strings.HasSuffix(orphan, ".log") {
+			// the only record of what happened to a keystore that is gone
+			fmt.Printf("Left the orphaned access log %s in place\n", orphan)
+			continue
+		}
+		if err := os.Remove(filepath.Join(dataDir, orphan)); err != nil {
+			fmt.Printf("Failed to remove orphaned %s: %v\n", orphan, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Removed orphaned %s\n", orphan)
+	}
+
+	names, err := normalizeKeystoreNames(args)
+	if err != nil {
+		fmt.Println(err)
+		return ExitError
+	}
+	if len(names) == 0 {
+		names = scan.keystores
+	}
+
+	for _, name := range names {
+		if err, ok := scan.malformedFiles[name]; ok {
+			fmt.Printf("Skipping %s, the keystore file is malformed: %v\n", name, err)
+			failed = true
+			continue
+		}
+
+		keystorePath := filepath.Join(dataDir, name+".json")
+		keystoreID := filepath.Base(keystorePath)
+		setCurrentKeystoreID(keystoreID)
+
+		fmt.Printf("Repairing %s\n", name)
+		password, err := promptForPassword(false, keystoreID)
+		if err != nil {
+			fmt.Println("Failed to read password:", err)
+			failed = true
+			continue
+		}
+		ks, err := loadKeystore(keystorePath, password)
+		if err != nil {
+			fmt.Println("Failed to load keystore:", err)
+			failed = true
+			continue
+		}
+		storeKeystorePassword(keystoreID, password)
+
+		identifiers := sortedIdentifiers(ks)
+		data, err := json.Marshal(identifiers)
+		if err != nil {
+			fmt.Println("Failed to marshal index:", err)
+			failed = true
+			continue
+		}
+		if err := utils.WriteFileAtomic(getIndexFilePath(name), data, utils.PrivateFileMode); err != nil {
+			fmt.Println("Failed to write index:", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Rebuilt index of %s with %d identifiers\n", name, len(identifiers))
+		writeExpiryIndex(keystorePath, ks)
+		if _, malformed := scan.malformedAttempts[name]; malformed {
+			os.Remove(attemptsPath(keystorePath))
+		}
+
+		for _, id := range identifiers {
+			value, err := decryptEntry(ks, ks.Passwords[id], password, name, id)
+			if err != nil {
+				color.Yellow("%s/%s does not decrypt and was left in place: %v", name, id, err)
+				continue
+			}
+			value.Destroy()
+		}
+	}
+
+	if failed {
+		return ExitError
+	}
+	return ExitClean
+}
+
+func sortedIdentifiers(ks *Keystore) []string {
+	identifiers := make([]string, 0, len(ks.Passwords))
+	for id := range ks.Passwords {
+		identifiers = append(identifiers, id)
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
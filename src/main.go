@@ -11,7 +11,6 @@ import (
 	// "io"
 	// "io/ioutil"
 	"os"
-	"path/filepath"
 
 	// "strings"
 
@@ -30,77 +29,325 @@ import (
 )
 
 func main() {
-	if len(os.Args) < 2 || os.Args[1] == "help" {
+	// secrets must not end up in a core file, see utils/secure.go
+	utils.DisableCoreDumps()
+	defer utils.DestroySecrets()
+
+	args, err := cmd.ParseGlobalFlags(os.Args)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	config, err := utils.LoadConfig()
+	if err != nil {
+		fmt.Println("Invalid config:", err)
+		// `config` must keep working so the bad key can be fixed
+		if len(args) < 2 || args[1] != "config" {
+			os.Exit(1)
+		}
+	}
+	if states.GlobalProfile != "" {
+		config = config.ForProfile(states.GlobalProfile)
+	}
+	states.GlobalConfig = config
+	cmd.ApplyColorSetting()
+
+	if len(args) < 2 || args[1] == "help" {
 		cmd.DisplayHelp()
 		return
 	}
 
+	switch args[1] {
+	case "config":
+		cmd.ConfigCommand(args[2:])
+		return
+	case "clear-clipboard":
+		if len(args) == 3 {
+			cmd.ClearClipboardAfter(args[2])
+		}
+		return
+	}
+
 	states.GlobalDataDirectory = utils.GetFullDataDir()
 	var dataDir = states.GlobalDataDirectory
 
-	mode := os.Args[1]
-	var identifier, keystoreName, keystorePath string
+	if args[1] == "fix-permissions" {
+		cmd.FixPermissionsCommand(dataDir)
+		return
+	}
+	if !cmd.CheckDataPermissions(dataDir) {
+		exit(1)
+	}
+
+	mode := args[1]
+	var identifier, keystoreName, keystorePath, keyfilePath string
 
 	switch mode {
 	case "create":
-		if len(os.Args) != 3 {
-			fmt.Println("Usage for create: snowpass create [keystore]")
+		positional, keyfile, removeKeyfile, err := cmd.SplitKeyfileOptions(args[2:])
+		if err != nil {
+			fmt.Println(err)
 			return
 		}
-		keystoreName = os.Args[2]
-	case "add", "get", "copy":
-		if len(os.Args) < 5 {
-			fmt.Println("Usage for add: snowpass add [identifier] to [keystore]")
-			fmt.Println("Usage for get: snowpass get [identifier] from [keystore]")
+		if len(positional) != 1 || removeKeyfile {
+			fmt.Println("Usage for create: snowpass create [keystore] [--keyfile path]")
 			return
 		}
-		identifier = os.Args[2]
-		if (mode == "add" && os.Args[3] != "to") || (mode == "get" && os.Args[3] != "from") {
-			fmt.Printf("Invalid syntax for '%s'. Use `help` for more info.\n", mode)
+		keystoreName = positional[0]
+		keyfilePath = keyfile
+	case "add":
+		positional, generate, policySpec, copyValue, err := cmd.SplitAddOptions(args[2:])
+		if err != nil {
+			fmt.Println(err)
 			return
 		}
-		keystoreName = os.Args[4]
-	case "edit":
-		identifier = os.Args[2]
-		keystoreName = os.Args[4]
-		keystorePath = filepath.Join(dataDir, keystoreName+".json")
-		cmd.EditInKeystore(keystorePath, identifier)
+		identifier, keystoreName, err = cmd.ResolveEntryArgs(mode, positional, dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if generate {
+			keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			cmd.AddGeneratedToKeystore(keystorePath, identifier, keystoreName, policySpec, copyValue)
+			return
+		}
+	case "otp":
+		positional := []string{}
+		copyValue := false
+		for _, arg := range args[2:] {
+			if arg == "--copy" {
+				copyValue = true
+				continue
+			}
+			positional = append(positional, arg)
+		}
+		identifier, keystoreName, err = cmd.ResolveEntryArgs(mode, positional, dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cmd.ShowOTP(keystorePath, identifier, copyValue)
 		return
-	case "delete":
-		identifier = os.Args[2]
-		keystoreName = os.Args[4]
-		keystorePath = filepath.Join(dataDir, keystoreName+".json")
-		cmd.DeleteFromKeystore(keystorePath, identifier, keystoreName)
+	case "share":
+		positional, opts, err := cmd.SplitShareOptions(args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		identifier, keystoreName, err = cmd.ResolveEntryArgs(mode, positional, dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cmd.ShareEntry(keystorePath, identifier, opts)
 		return
+	case "receive":
+		positional, as, err := cmd.SplitReceiveOptions(args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		source, keystoreName, err := cmd.ResolveEntryArgs(mode, positional, dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cmd.ReceiveBundle(keystorePath, source, keystoreName, as)
+		return
+	case "protect", "unprotect":
+		positional := []string{}
+		passphrase := false
+		for _, arg := range args[2:] {
+			if arg == "--passphrase" && mode == "protect" {
+				passphrase = true
+				continue
+			}
+			positional = append(positional, arg)
+		}
+		identifier, keystoreName, err = cmd.ResolveEntryArgs(mode, positional, dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if mode == "protect" {
+			cmd.ProtectEntry(keystorePath, identifier, passphrase)
+		} else {
+			cmd.UnprotectEntry(keystorePath, identifier)
+		}
+		return
+	case "expiry":
+		positional, expires, rotateEvery, err := cmd.SplitExpiryOptions(args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		identifier, keystoreName, err = cmd.ResolveEntryArgs(mode, positional, dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cmd.SetEntryExpiry(keystorePath, identifier, expires, rotateEvery)
+		return
+	case "run":
+		exit(cmd.Run(dataDir, args[2:]))
+	case "inject":
+		exit(cmd.Inject(dataDir, args[2:]))
+	case "expiring":
+		exit(cmd.Expiring(dataDir, args[2:]))
+	case "get", "copy", "edit", "delete":
+		identifier, keystoreName, err = cmd.ResolveEntryArgs(mode, args[2:], dataDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	case "breach-check":
+		var hibpPath string
+		positional := []string{}
+		for i := 2; i < len(args); i++ {
+			if args[i] == "--hibp" && i+1 < len(args) {
+				i++
+				hibpPath = args[i]
+				continue
+			}
+			positional = append(positional, args[i])
+		}
+		switch len(positional) {
+		case 0:
+			keystoreName = states.GlobalConfig.DefaultKeystore
+		case 1:
+			keystoreName = positional[0]
+		}
+		if keystoreName == "" {
+			fmt.Println("Usage for breach-check: snowpass breach-check [keystore] [--hibp path]")
+			return
+		}
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cmd.BreachCheck(keystorePath, hibpPath)
+		return
+	case "audit":
+		exit(cmd.Audit(dataDir, args[2:]))
+	case "log":
+		exit(cmd.ShowAccessLog(dataDir, args[2:]))
+	case "verify":
+		exit(cmd.Verify(dataDir, args[2:]))
+	case "repair":
+		exit(cmd.Repair(dataDir, args[2:]))
 	case "delete-keystore":
-		keystoreName = os.Args[2]
-		keystorePath = filepath.Join(dataDir, keystoreName+".json")
+		if len(args) != 3 {
+			fmt.Println("Usage for delete-keystore: snowpass delete-keystore [keystore]")
+			return
+		}
+		keystoreName = args[2]
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 		cmd.DeleteKeystore(keystorePath)
 		return
 	case "change-password":
-		keystoreName = os.Args[2]
-		keystorePath = filepath.Join(dataDir, keystoreName+".json")
-		cmd.ChangeMasterPassword(keystorePath)
+		positional, keyfile, removeKeyfile, err := cmd.SplitKeyfileOptions(args[2:])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(positional) != 1 {
+			fmt.Println("Usage for change-password: snowpass change-password [keystore] [--keyfile path | --remove-keyfile]")
+			return
+		}
+		keystoreName = positional[0]
+		keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cmd.ChangeMasterPassword(keystorePath, keyfile, removeKeyfile)
 		return
 	case "list":
 		cmd.ListAllKeystores(dataDir)
 		return
+	case "slots":
+		cmd.SlotsCommand(dataDir, args[2:])
+		return
+	case "split-recovery":
+		cmd.SplitRecovery(dataDir, args[2:])
+		return
+	case "recover":
+		cmd.Recover(dataDir, args[2:])
+		return
+	case "members":
+		cmd.MembersCommand(dataDir, args[2:])
+		return
+	case "identity":
+		cmd.IdentityCommand()
+		return
+	case "use":
+		cmd.UseKeystore(dataDir, args[2:])
+		return
+	case "generate":
+		cmd.GeneratePasswordCommand(args[2:])
+		return
+	case "passphrase":
+		cmd.PassphraseCommand(args[2:])
+		return
 	default:
-		fmt.Println("Invalid mode. Use 'create', 'add', 'get', or 'list'.")
+		fmt.Println("Invalid mode. Use `help` to see all modes.")
 		return
 	}
 
-	keystorePath = filepath.Join(dataDir, keystoreName+".json")
+	keystoreName, keystorePath, err = cmd.KeystorePath(dataDir, keystoreName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	switch mode {
 	case "create":
-		cmd.CreateKeystore(keystorePath, keystoreName)
+		cmd.CreateKeystore(keystorePath, keystoreName, keyfilePath)
 	case "add":
 		cmd.AddToKeystore(keystorePath, identifier, keystoreName)
 	case "get":
 		cmd.GetFromKeystore(keystorePath, identifier)
 	case "copy":
 		cmd.CopyToClipboard(keystorePath, identifier)
+	case "edit":
+		cmd.EditInKeystore(keystorePath, identifier)
+	case "delete":
+		cmd.DeleteFromKeystore(keystorePath, identifier, keystoreName)
 
 		// idk why this isn't working
 		// do not uncomment since everything breaks
@@ -114,3 +361,10 @@ func main() {
 		}*/
 	}
 }
+
+// exit wipes the remaining secrets before exiting, which deferred calls
+// would not get to do.
+func exit(code int) {
+	utils.DestroySecrets()
+	os.Exit(code)
+}
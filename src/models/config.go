@@ -0,0 +1,190 @@
+package models
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// Duration wraps time.Duration so it can be written as "20m" or "45s" in
+// the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// StrengthConfig holds the zxcvbn scores values are refused below, and the
+// score audit reports entries below as weak.
+type StrengthConfig struct {
+	MinScore      int `toml:"min_score"`
+	MinEntryScore int `toml:"min_entry_score"`
+	WeakScore     int `toml:"weak_score"`
+}
+
+// HIBPConfig points at a local copy of the Have I Been Pwned SHA-1 password
+// list, either the single sorted file or a directory of range files.
+type HIBPConfig struct {
+	Path       string `toml:"path"`
+	CheckOnAdd bool   `toml:"check_on_add"`
+}
+
+// UnlockConfig slows down guessing: after FreeAttempts failed unlocks each
+// further attempt waits Delay, doubled for every failure, up to MaxDelay.
+type UnlockConfig struct {
+	FreeAttempts int      `toml:"free_attempts"`
+	Delay        Duration `toml:"delay"`
+	MaxDelay     Duration `toml:"max_delay"`
+}
+
+type KDFConfig struct {
+	ScryptN int `toml:"scrypt_n"`
+	ScryptR int `toml:"scrypt_r"`
+	ScryptP int `toml:"scrypt_p"`
+}
+
+// KeystoreConfig holds the settings that can be overridden for a single
+// keystore. Nil fields fall back to the global value.
+type KeystoreConfig struct {
+	SessionTimeout   *Duration        `toml:"session_timeout"`
+	ClipboardTimeout *Duration        `toml:"clipboard_timeout"`
+	KDF              KDFOverride      `toml:"kdf"`
+	Strength         StrengthOverride `toml:"strength"`
+	Keyfile          *string          `toml:"keyfile"`
+	ExpiryIndex      *bool            `toml:"expiry_index"`
+}
+
+type StrengthOverride struct {
+	MinScore      *int `toml:"min_score"`
+	MinEntryScore *int `toml:"min_entry_score"`
+	WeakScore     *int `toml:"weak_score"`
+}
+
+type KDFOverride struct {
+	ScryptN *int `toml:"scrypt_n"`
+	ScryptR *int `toml:"scrypt_r"`
+	ScryptP *int `toml:"scrypt_p"`
+}
+
+type Config struct {
+	DataDir          string                    `toml:"data_dir"`
+	DefaultKeystore  string                    `toml:"default_keystore"`
+	SessionTimeout   Duration                  `toml:"session_timeout"`
+	ClipboardTimeout Duration                  `toml:"clipboard_timeout"`
+	KDF              KDFConfig                 `toml:"kdf"`
+	Strength         StrengthConfig            `toml:"strength"`
+	HIBP             HIBPConfig                `toml:"hibp"`
+	Unlock           UnlockConfig              `toml:"unlock"`
+	Keyfile          string                    `toml:"keyfile"`
+	Identity         string                    `toml:"identity"`
+	Color            string                    `toml:"color"`
+	Output           string                    `toml:"output"`
+	Permissions      string                    `toml:"permissions"`
+	ExpiryIndex      bool                      `toml:"expiry_index"`
+	Keystores        map[string]KeystoreConfig `toml:"keystores"`
+	Profiles         map[string]ProfileConfig  `toml:"profiles"`
+}
+
+// ProfileConfig holds the settings of a named profile. A profile without a
+// data_dir gets its own directory below the default one.
+type ProfileConfig struct {
+	DataDir         string `toml:"data_dir"`
+	DefaultKeystore string `toml:"default_keystore"`
+}
+
+// DefaultConfig returns the values snowpass used before the config file
+// existed.
+func DefaultConfig() Config {
+	return Config{
+		SessionTimeout: Duration{20 * time.Minute},
+		KDF: KDFConfig{
+			ScryptN: 1 << 15,
+			ScryptR: 8,
+			ScryptP: 1,
+		},
+		Strength: StrengthConfig{
+			WeakScore: 3,
+		},
+		Unlock: UnlockConfig{
+			FreeAttempts: 3,
+			Delay:        Duration{time.Second},
+			MaxDelay:     Duration{5 * time.Minute},
+		},
+		Color:       "auto",
+		Output:      "text",
+		Permissions: "warn",
+		Keystores:   make(map[string]KeystoreConfig),
+		Profiles:    make(map[string]ProfileConfig),
+	}
+}
+
+// ForKeystore returns a copy of the config with the overrides for the given
+// keystore applied on top of the global values.
+func (c Config) ForKeystore(keystoreName string) Config {
+	override, ok := c.Keystores[keystoreName]
+	if !ok {
+		return c
+	}
+
+	if override.SessionTimeout != nil {
+		c.SessionTimeout = *override.SessionTimeout
+	}
+	if override.ClipboardTimeout != nil {
+		c.ClipboardTimeout = *override.ClipboardTimeout
+	}
+	if override.KDF.ScryptN != nil {
+		c.KDF.ScryptN = *override.KDF.ScryptN
+	}
+	if override.KDF.ScryptR != nil {
+		c.KDF.ScryptR = *override.KDF.ScryptR
+	}
+	if override.KDF.ScryptP != nil {
+		c.KDF.ScryptP = *override.KDF.ScryptP
+	}
+	if override.Strength.MinScore != nil {
+		c.Strength.MinScore = *override.Strength.MinScore
+	}
+	if override.Strength.MinEntryScore != nil {
+		c.Strength.MinEntryScore = *override.Strength.MinEntryScore
+	}
+	if override.Strength.WeakScore != nil {
+		c.Strength.WeakScore = *override.Strength.WeakScore
+	}
+	if override.Keyfile != nil {
+		c.Keyfile = *override.Keyfile
+	}
+	if override.ExpiryIndex != nil {
+		c.ExpiryIndex = *override.ExpiryIndex
+	}
+
+	return c
+}
+
+// ForProfile returns a copy of the config with the settings of the given
+// profile applied. Profiles do not share the global default keystore, and a
+// profile without its own data_dir lives in a "profiles" directory below the
+// global one. An empty DataDir is resolved against the platform default by
+// the caller.
+func (c Config) ForProfile(profileName string) Config {
+	profile := c.Profiles[profileName]
+
+	switch {
+	case profile.DataDir != "":
+		c.DataDir = profile.DataDir
+	case c.DataDir != "":
+		c.DataDir = filepath.Join(c.DataDir, "profiles", profileName)
+	}
+	c.DefaultKeystore = profile.DefaultKeystore
+
+	return c
+}
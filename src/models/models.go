@@ -1,5 +1,103 @@
 package models
 
+import "time"
+
 type Keystore struct {
 	Passwords map[string]string
+	// Modified records when each entry was last written. Entries written
+	// before it existed have no timestamp.
+	Modified map[string]time.Time `json:",omitempty"`
+	// BoundEntries is set once every entry is encrypted with its keystore
+	// name and identifier as associated data.
+	BoundEntries bool `json:",omitempty"`
+	// AttemptsIdentity is the age identity that opens the records of failed
+	// unlocks, which are encrypted to the recipient in the header.
+	AttemptsIdentity string `json:",omitempty"`
+	// LogKey encrypts the access log, and LogHead is its last record, so
+	// that a log cut short is noticed.
+	LogKey  string   `json:",omitempty"`
+	LogHead *LogHead `json:",omitempty"`
+	// Entries holds the settings of entries that have any, by identifier.
+	Entries map[string]EntryMeta `json:",omitempty"`
+
+	// Keyfile is the digest of the keyfile the keystore was unlocked with.
+	// It is never written anywhere.
+	Keyfile []byte `json:"-"`
+	// Header, DataKey and UnlockedSlot are filled in when the keystore is
+	// unlocked through a key slot.
+	Header       KeystoreHeader `json:"-"`
+	DataKey      Secret         `json:"-"`
+	UnlockedSlot int            `json:"-"`
+}
+
+// Secret is key material kept outside the Go heap, a *utils.SecureBuffer,
+// which models cannot name without an import cycle.
+type Secret interface {
+	Bytes() []byte
+	Destroy()
+}
+
+// EntryMeta holds the settings of a single entry.
+type EntryMeta struct {
+	// Sensitive entries ask for the master password again even when a
+	// session is active.
+	Sensitive bool `json:",omitempty"`
+	// Passphrase is set when the value is encrypted with a passphrase of its
+	// own before it is encrypted with the keystore.
+	Passphrase bool `json:",omitempty"`
+	// Expires is when the value stops working, RotateEvery how often it
+	// should be changed, counted from the last change.
+	Expires     *time.Time `json:",omitempty"`
+	RotateEvery *Duration  `json:",omitempty"`
+}
+
+// LogHead points at a record of the access log by its sequence number and
+// the SHA-256 of its line.
+type LogHead struct {
+	Seq  int
+	Hash string
+}
+
+// KeystoreHeader is kept in the clear in front of the encrypted keystore.
+type KeystoreHeader struct {
+	Version int
+	// Keyfile is set when a version 2 keystore needs a keyfile next to the
+	// master password. Nothing about the keyfile itself is recorded. Since
+	// version 3 each key slot records it instead.
+	Keyfile bool `json:",omitempty"`
+	// Slots each wrap the random data key the keystore is encrypted with,
+	// any one of them unlocks it.
+	Slots []KeySlot `json:",omitempty"`
+	// AttemptsRecipient is the age recipient failed unlocks are recorded
+	// for, see Keystore.AttemptsIdentity.
+	AttemptsRecipient string `json:",omitempty"`
+	// Name is the keystore name the file was written under. Everything in
+	// it is bound to that name, so a renamed file no longer opens; the name
+	// tells that apart from a wrong password.
+	Name string `json:",omitempty"`
+}
+
+// KeySlot holds the data key of a keystore encrypted with one secret, either
+// a master password, a recovery key or a secret split into shares.
+type KeySlot struct {
+	ID      int
+	Type    string
+	Keyfile bool `json:",omitempty"`
+	Created time.Time
+	Wrapped string
+	// Member and Recipient name the age recipient of a member slot.
+	Member    string `json:",omitempty"`
+	Recipient string `json:",omitempty"`
+	// ShareSet, Threshold and Shares describe a secret split with
+	// `split-recovery`.
+	ShareSet  string `json:",omitempty"`
+	Threshold int    `json:",omitempty"`
+	Shares    int    `json:",omitempty"`
+}
+
+// KeystoreFile is the layout of a keystore file. Files written before the
+// header existed hold only the encrypted data.
+type KeystoreFile struct {
+	Header KeystoreHeader
+	Data   string
 }
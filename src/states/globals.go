@@ -1,3 +1,12 @@
 package states
 
+import "github.com/fluffysnowman/snowpass/models"
+
 var GlobalDataDirectory string
+
+// GlobalStoreOverride is set from --store or SNOWPASS_HOME and GlobalProfile
+// from --profile or SNOWPASS_PROFILE. Both are empty by default.
+var GlobalStoreOverride string
+var GlobalProfile string
+
+var GlobalConfig = models.DefaultConfig()
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/fluffysnowman/snowpass/states"
+)
+
+// GetIdentityPath returns the age identity file of this user, from the
+// identity setting or next to the config file.
+func GetIdentityPath() (string, error) {
+	if path := states.GlobalConfig.Identity; path != "" {
+		return ExpandHome(path)
+	}
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "identity.txt"), nil
+}
+
+// LoadIdentities reads the X25519 identities from an age identity file, as
+// written by GenerateIdentity or age-keygen.
+func LoadIdentities(path string) ([]*age.X25519Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	parsed, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, err
+	}
+	var identities []*age.X25519Identity
+	for _, identity := range parsed {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			identities = append(identities, x25519)
+		}
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no X25519 identity in %s", path)
+	}
+	return identities, nil
+}
+
+// GenerateIdentity writes a new identity file in the format of age-keygen.
+func GenerateIdentity(path string) (*age.X25519Identity, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return identity, f.Close()
+}
+
+// ParseRecipient accepts an age X25519 recipient ("age1...").
+func ParseRecipient(recipient string) (*age.X25519Recipient, error) {
+	return age.ParseX25519Recipient(strings.TrimSpace(recipient))
+}
+
+// AgeEncrypt encrypts data to the recipients in the age format and returns
+// it base64 encoded.
+func AgeEncrypt(data []byte, recipients ...age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// AgeDecrypt reverses AgeEncrypt with any of the identities. The plaintext
+// is returned in a SecureBuffer for the caller to destroy.
+func AgeDecrypt(encoded string, identities ...age.Identity) (*SecureBuffer, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return readSecure(r)
+}
+
+// readSecure is io.ReadAll into a SecureBuffer. Every buffer it outgrows is
+// destroyed, so no partial copies are left behind.
+func readSecure(r io.Reader) (*SecureBuffer, error) {
+	buf := NewSecureBuffer(512)
+	n := 0
+	for {
+		if n == len(buf.Bytes()) {
+			bigger := NewSecureBuffer(2 * n)
+			copy(bigger.Bytes(), buf.Bytes())
+			buf.Destroy()
+			buf = bigger
+		}
+		read, err := r.Read(buf.Bytes()[n:])
+		n += read
+		if err == io.EOF {
+			return buf.Truncate(n), nil
+		}
+		if err != nil {
+			buf.Destroy()
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"filippo.io/age"
+)
+
+// A bundle carries a single entry to someone else, see "Bundle format" in
+// the README. The outer JSON is readable by anyone; the payload is an age
+// file encrypted either with a one-time passphrase (age's scrypt recipient)
+// or to the receiver's X25519 recipient.
+const (
+	BundleFormat  = "snowpass-bundle"
+	BundleVersion = 1
+
+	BundleProtectionPassphrase = "passphrase"
+	BundleProtectionRecipient  = "x25519"
+
+	bundleArmorBegin = "-----BEGIN SNOWPASS BUNDLE-----"
+	bundleArmorEnd   = "-----END SNOWPASS BUNDLE-----"
+)
+
+type Bundle struct {
+	Format     string     `json:"format"`
+	Version    int        `json:"version"`
+	Protection string     `json:"protection"`
+	Recipient  string     `json:"recipient,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Payload    string     `json:"payload"`
+}
+
+// BundleEntry is the encrypted content of a bundle. Its expiry is the one
+// that counts, the copy in the outer bundle is only informational. The value
+// is encoded by hand, as encoding/json would leave copies of it behind.
+type BundleEntry struct {
+	Identifier string        `json:"identifier"`
+	Value      *SecureBuffer `json:"-"`
+	Created    time.Time     `json:"created"`
+	Expires    *time.Time    `json:"expires,omitempty"`
+}
+
+// marshalBundleEntry renders entry as the JSON payload of a bundle.
+func marshalBundleEntry(entry BundleEntry) (*SecureBuffer, error) {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	const valueKey = `{"value":`
+	value := entry.Value.Bytes()
+	plaintext := NewSecureBuffer(len(valueKey) + jsonStringLen(value) + len(meta))
+	out := plaintext.Bytes()
+	n := copy(out, valueKey)
+	n += putJSONString(out[n:], value)
+	out[n] = ','
+	copy(out[n+1:], meta[1:])
+	return plaintext, nil
+}
+
+// unmarshalBundleEntry reverses marshalBundleEntry. The caller destroys the
+// value of the entry.
+func unmarshalBundleEntry(plaintext []byte) (*BundleEntry, error) {
+	var fields struct {
+		BundleEntry
+		Value json.RawMessage `json:"value"`
+	}
+	err := json.Unmarshal(plaintext, &fields)
+	defer Wipe(fields.Value)
+	if err != nil {
+		return nil, err
+	}
+	entry := fields.BundleEntry
+	if entry.Value, err = parseJSONString(fields.Value); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// jsonStringLen is the length of src quoted as a JSON string.
+func jsonStringLen(src []byte) int {
+	n := 2
+	for _, c := range src {
+		switch {
+		case c == '"' || c == '\\':
+			n += 2
+		case c < 0x20:
+			n += 6
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// putJSONString writes src quoted as a JSON string to dst, which has room
+// for jsonStringLen(src) bytes, and returns the number of bytes written.
+func putJSONString(dst, src []byte) int {
+	const hexDigits = "0123456789abcdef"
+	dst[0] = '"'
+	n := 1
+	for _, c := range src {
+		switch {
+		case c == '"' || c == '\\':
+			dst[n], dst[n+1] = '\\', c
+			n += 2
+		case c < 0x20:
+			copy(dst[n:], `\u00`)
+			dst[n+4], dst[n+5] = hexDigits[c>>4], hexDigits[c&0xf]
+			n += 6
+		default:
+			dst[n] = c
+			n++
+		}
+	}
+	dst[n] = '"'
+	return n + 1
+}
+
+// parseJSONString decodes a JSON string, quotes included, into a
+// SecureBuffer.
+func parseJSONString(raw []byte) (*SecureBuffer, error) {
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return nil, fmt.Errorf("invalid bundle payload: the value is not a string")
+	}
+	raw = raw[1 : len(raw)-1]
+
+	// unescaping never makes the value longer
+	buf := NewSecureBuffer(len(raw))
+	out := buf.Bytes()
+	n := 0
+	for i := 0; i < len(raw); {
+		if raw[i] != '\\' {
+			out[n] = raw[i]
+			n++
+			i++
+			continue
+		}
+		if i+1 == len(raw) {
+			buf.Destroy()
+			return nil, fmt.Errorf("invalid bundle payload: bad escape in the value")
+		}
+		switch c := raw[i+1]; c {
+		case '"', '\\', '/':
+			out[n] = c
+		case 'b':
+			out[n] = '\b'
+		case 'f':
+			out[n] = '\f'
+		case 'n':
+			out[n] = '\n'
+		case 'r':
+			out[n] = '\r'
+		case 't':
+			out[n] = '\t'
+		case 'u':
+			r, size := parseJSONEscape(raw[i:])
+			if size == 0 {
+				buf.Destroy()
+				return nil, fmt.Errorf("invalid bundle payload: bad escape in the value")
+			}
+			n += utf8.EncodeRune(out[n:], r)
+			i += size
+			continue
+		default:
+			buf.Destroy()
+			return nil, fmt.Errorf("invalid bundle payload: bad escape in the value")
+		}
+		n++
+		i += 2
+	}
+	return buf.Truncate(n), nil
+}
+
+// parseJSONEscape decodes the \uXXXX escape at the start of s, or a pair of
+// them for a character outside the BMP, and returns the character and the
+// number of bytes used, 0 if the escape is malformed.
+func parseJSONEscape(s []byte) (rune, int) {
+	r, ok := parseHex4(s)
+	if !ok {
+		return 0, 0
+	}
+	if !utf16.IsSurrogate(r) {
+		return r, 6
+	}
+	if len(s) >= 12 && s[6] == '\\' && s[7] == 'u' {
+		if r2, ok := parseHex4(s[6:]); ok {
+			if pair := utf16.DecodeRune(r, r2); pair != utf8.RuneError {
+				return pair, 12
+			}
+		}
+	}
+	// a lone surrogate, which encoding/json also turns into U+FFFD
+	return utf8.RuneError, 6
+}
+
+func parseHex4(s []byte) (rune, bool) {
+	if len(s) < 6 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range s[2:6] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+// SealBundle encrypts entry to the age recipient.
+func SealBundle(entry BundleEntry, protection string, recipient age.Recipient, recipientName string) (*Bundle, error) {
+	plaintext, err := marshalBundleEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := AgeEncrypt(plaintext.Bytes(), recipient)
+	plaintext.Destroy()
+	if err != nil {
+		return nil, err
+	}
+	return &Bundle{
+		Format:     BundleFormat,
+		Version:    BundleVersion,
+		Protection: protection,
+		Recipient:  recipientName,
+		Expires:    entry.Expires,
+		Payload:    payload,
+	}, nil
+}
+
+// OpenBundle decrypts a bundle and refuses it once it has expired. The
+// caller destroys the value of the entry.
+func OpenBundle(bundle *Bundle, identity age.Identity) (*BundleEntry, error) {
+	plaintext, err := AgeDecrypt(bundle.Payload, identity)
+	if err != nil {
+		return nil, err
+	}
+	defer plaintext.Destroy()
+	entry, err := unmarshalBundleEntry(plaintext.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle payload: %v", err)
+	}
+	if entry.Expires != nil && time.Now().After(*entry.Expires) {
+		entry.Value.Destroy()
+		return nil, fmt.Errorf("the bundle expired on %s", entry.Expires.Local().Format("2006-01-02 15:04"))
+	}
+	return entry, nil
+}
+
+// Armor renders the bundle as text that survives being pasted into chats
+// and emails.
+func (b *Bundle) Armor() (string, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var out strings.Builder
+	out.WriteString(bundleArmorBegin + "\n")
+	for len(encoded) > 64 {
+		out.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	out.WriteString(encoded + "\n")
+	out.WriteString(bundleArmorEnd + "\n")
+	return out.String(), nil
+}
+
+// ParseBundle accepts both the JSON file and the armored text.
+func ParseBundle(data []byte) (*Bundle, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte(bundleArmorBegin)) {
+		body := strings.TrimPrefix(string(data), bundleArmorBegin)
+		end := strings.Index(body, bundleArmorEnd)
+		if end < 0 {
+			return nil, fmt.Errorf("armored bundle is missing its end line")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body[:end]), ""))
+		if err != nil {
+			return nil, fmt.Errorf("armored bundle is not valid base64")
+		}
+		data = decoded
+	}
+
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("not a snowpass bundle: %v", err)
+	}
+	if bundle.Format != BundleFormat {
+		return nil, fmt.Errorf("not a snowpass bundle")
+	}
+	if bundle.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	return &bundle, nil
+}
+
+// ReadBundle reads a bundle given as armored text, as a file, or from
+// standard input for "-".
+func ReadBundle(source string, stdin io.Reader) (*Bundle, error) {
+	if strings.HasPrefix(strings.TrimSpace(source), bundleArmorBegin) {
+		return ParseBundle([]byte(source))
+	}
+
+	var data []byte
+	var err error
+	if source == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		var path string
+		path, err = ExpandHome(source)
+		if err == nil {
+			data, err = os.ReadFile(path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseBundle(data)
+}
+
+// ParseLongDuration is time.ParseDuration that also accepts whole days, as
+// in "30d".
+func ParseLongDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var bundleValues = []string{
+	"",
+	"hunter2",
+	`quote " and backslash \ and slash /`,
+	"line\nbreak\ttab\r\x00\x1f",
+	"café 日本 🔑",
+}
+
+func TestBundleEntryRoundTrip(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, value := range bundleValues {
+		entry := BundleEntry{
+			Identifier: "db",
+			Value:      SecureBytes([]byte(value)),
+			Created:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			Expires:    &expires,
+		}
+		plaintext, err := marshalBundleEntry(entry)
+		entry.Value.Destroy()
+		if err != nil {
+			t.Fatalf("marshalBundleEntry(%q): %v", value, err)
+		}
+
+		// the payload is still what encoding/json reads
+		var plain struct {
+			Identifier string    `json:"identifier"`
+			Value      string    `json:"value"`
+			Created    time.Time `json:"created"`
+		}
+		if err := json.Unmarshal(plaintext.Bytes(), &plain); err != nil {
+			t.Fatalf("json.Unmarshal(%q): %v", plaintext.Bytes(), err)
+		}
+		if plain.Value != value || plain.Identifier != "db" {
+			t.Errorf("json.Unmarshal(%q) = %q, want %q", plaintext.Bytes(), plain.Value, value)
+		}
+
+		got, err := unmarshalBundleEntry(plaintext.Bytes())
+		plaintext.Destroy()
+		if err != nil {
+			t.Fatalf("unmarshalBundleEntry(%q): %v", value, err)
+		}
+		if string(got.Value.Bytes()) != value || got.Expires == nil || !got.Expires.Equal(expires) {
+			t.Errorf("round trip of %q = %q", value, got.Value.Bytes())
+		}
+		got.Value.Destroy()
+	}
+}
+
+func TestUnmarshalBundleEntryReadsEncodingJSON(t *testing.T) {
+	// bundles written before the value was encoded by hand
+	for _, value := range append(bundleValues, "<&> ") {
+		plaintext, err := json.Marshal(map[string]string{"identifier": "db", "value": value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := unmarshalBundleEntry(plaintext)
+		if err != nil {
+			t.Fatalf("unmarshalBundleEntry(%s): %v", plaintext, err)
+		}
+		if string(got.Value.Bytes()) != value {
+			t.Errorf("unmarshalBundleEntry(%s) = %q, want %q", plaintext, got.Value.Bytes(), value)
+		}
+		got.Value.Destroy()
+	}
+}
+
+func TestUnmarshalBundleEntryRejects(t *testing.T) {
+	rejected := []string{
+		`{"identifier":"db","value":12}`,
+		`{"identifier":"db","value":"\x"}`,
+		`{"identifier":"db","value":"\u12"}`,
+		`{"identifier":"db"}`,
+	}
+	for _, plaintext := range rejected {
+		if got, err := unmarshalBundleEntry([]byte(plaintext)); err == nil {
+			t.Errorf("unmarshalBundleEntry(%s) = %q, want an error", plaintext, got.Value.Bytes())
+		}
+	}
+}
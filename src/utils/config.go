@@ -0,0 +1,634 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/fluffysnowman/snowpass/models"
+)
+
+const (
+	kindString   = "string"
+	kindDuration = "duration"
+	kindInt      = "int"
+	kindBool     = "bool"
+)
+
+// ConfigKey describes a single key that may appear in config.toml.
+// Overridable keys may also appear under a [keystores.<name>] section and
+// PerProfile keys under a [profiles.<name>] section.
+type ConfigKey struct {
+	Name        string
+	Kind        string
+	Overridable bool
+	PerProfile  bool
+	check       func(value interface{}) error
+	format      func(c models.Config) string
+}
+
+// configSections are the tables whose sub-tables override a subset of the
+// global keys for one keystore or one profile.
+var configSections = []string{"keystores", "profiles"}
+
+func (key ConfigKey) allowedIn(section string) bool {
+	switch section {
+	case "":
+		return true
+	case "keystores":
+		return key.Overridable
+	case "profiles":
+		return key.PerProfile
+	}
+	return false
+}
+
+var configKeys = []ConfigKey{
+	{
+		Name:       "data_dir",
+		Kind:       kindString,
+		PerProfile: true,
+		format: func(c models.Config) string {
+			return c.DataDir
+		},
+	},
+	{
+		Name:       "default_keystore",
+		Kind:       kindString,
+		PerProfile: true,
+		format: func(c models.Config) string {
+			return c.DefaultKeystore
+		},
+	},
+	{
+		Name:        "session_timeout",
+		Kind:        kindDuration,
+		Overridable: true,
+		check:       checkNonNegativeDuration,
+		format: func(c models.Config) string {
+			return c.SessionTimeout.String()
+		},
+	},
+	{
+		Name:        "clipboard_timeout",
+		Kind:        kindDuration,
+		Overridable: true,
+		check:       checkNonNegativeDuration,
+		format: func(c models.Config) string {
+			return c.ClipboardTimeout.String()
+		},
+	},
+	{
+		Name:        "kdf.scrypt_n",
+		Kind:        kindInt,
+		Overridable: true,
+		check: func(value interface{}) error {
+			n := value.(int64)
+			if n < 2 || n&(n-1) != 0 {
+				return fmt.Errorf("must be a power of two greater than 1")
+			}
+			return nil
+		},
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.KDF.ScryptN)
+		},
+	},
+	{
+		Name:        "kdf.scrypt_r",
+		Kind:        kindInt,
+		Overridable: true,
+		check:       checkPositiveInt,
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.KDF.ScryptR)
+		},
+	},
+	{
+		Name:        "kdf.scrypt_p",
+		Kind:        kindInt,
+		Overridable: true,
+		check:       checkPositiveInt,
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.KDF.ScryptP)
+		},
+	},
+	{
+		Name:        "strength.min_score",
+		Kind:        kindInt,
+		Overridable: true,
+		check:       checkIntRange(0, 4),
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.Strength.MinScore)
+		},
+	},
+	{
+		Name:        "strength.min_entry_score",
+		Kind:        kindInt,
+		Overridable: true,
+		check:       checkIntRange(0, 4),
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.Strength.MinEntryScore)
+		},
+	},
+	{
+		Name:        "strength.weak_score",
+		Kind:        kindInt,
+		Overridable: true,
+		check:       checkIntRange(0, 4),
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.Strength.WeakScore)
+		},
+	},
+	{
+		Name: "hibp.path",
+		Kind: kindString,
+		format: func(c models.Config) string {
+			return c.HIBP.Path
+		},
+	},
+	{
+		Name:  "unlock.free_attempts",
+		Kind:  kindInt,
+		check: checkIntRange(0, 1000),
+		format: func(c models.Config) string {
+			return strconv.Itoa(c.Unlock.FreeAttempts)
+		},
+	},
+	{
+		Name:  "unlock.delay",
+		Kind:  kindDuration,
+		check: checkNonNegativeDuration,
+		format: func(c models.Config) string {
+			return c.Unlock.Delay.String()
+		},
+	},
+	{
+		Name:  "unlock.max_delay",
+		Kind:  kindDuration,
+		check: checkNonNegativeDuration,
+		format: func(c models.Config) string {
+			return c.Unlock.MaxDelay.String()
+		},
+	},
+	{
+		Name: "hibp.check_on_add",
+		Kind: kindBool,
+		format: func(c models.Config) string {
+			return strconv.FormatBool(c.HIBP.CheckOnAdd)
+		},
+	},
+	{
+		Name:        "keyfile",
+		Kind:        kindString,
+		Overridable: true,
+		format: func(c models.Config) string {
+			return c.Keyfile
+		},
+	},
+	{
+		Name: "identity",
+		Kind: kindString,
+		format: func(c models.Config) string {
+			return c.Identity
+		},
+	},
+	{
+		Name:  "color",
+		Kind:  kindString,
+		check: checkOneOf("auto", "always", "never"),
+		format: func(c models.Config) string {
+			return c.Color
+		},
+	},
+	{
+		Name:  "output",
+		Kind:  kindString,
+		check: checkOneOf("text", "json"),
+		format: func(c models.Config) string {
+			return c.Output
+		},
+	},
+	{
+		Name:  "permissions",
+		Kind:  kindString,
+		check: checkOneOf("warn", "refuse", "ignore"),
+		format: func(c models.Config) string {
+			return c.Permissions
+		},
+	},
+	{
+		Name:        "expiry_index",
+		Kind:        kindBool,
+		Overridable: true,
+		format: func(c models.Config) string {
+			return strconv.FormatBool(c.ExpiryIndex)
+		},
+	},
+}
+
+func checkNonNegativeDuration(value interface{}) error {
+	d, err := time.ParseDuration(value.(string))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	if d < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+func checkPositiveInt(value interface{}) error {
+	if value.(int64) < 1 {
+		return fmt.Errorf("must be at least 1")
+	}
+	return nil
+}
+
+func checkIntRange(min, max int64) func(value interface{}) error {
+	return func(value interface{}) error {
+		if n := value.(int64); n < min || n > max {
+			return fmt.Errorf("must be between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+func checkOneOf(allowed ...string) func(value interface{}) error {
+	return func(value interface{}) error {
+		for _, a := range allowed {
+			if value.(string) == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func lookupConfigKey(name string) (ConfigKey, bool) {
+	for _, key := range configKeys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return ConfigKey{}, false
+}
+
+// splitConfigKey splits a dotted key such as "keystores.work.session_timeout"
+// into its section ("keystores"), the section name ("work") and the
+// registered key. Global keys have an empty section.
+func splitConfigKey(fullKey string) (string, string, ConfigKey, error) {
+	for _, section := range configSections {
+		if !strings.HasPrefix(fullKey, section+".") {
+			continue
+		}
+
+		rest := strings.TrimPrefix(fullKey, section+".")
+		for _, key := range configKeys {
+			if key.allowedIn(section) && strings.HasSuffix(rest, "."+key.Name) {
+				sectionName := strings.TrimSuffix(rest, "."+key.Name)
+				if sectionName != "" {
+					return section, sectionName, key, nil
+				}
+			}
+		}
+		return "", "", ConfigKey{}, fmt.Errorf("unknown key %q (%s sections accept: %s)", fullKey, section, strings.Join(sectionKeyNames(section), ", "))
+	}
+
+	key, ok := lookupConfigKey(fullKey)
+	if !ok {
+		return "", "", ConfigKey{}, fmt.Errorf("unknown key %q", fullKey)
+	}
+	return "", "", key, nil
+}
+
+func sectionKeyNames(section string) []string {
+	var names []string
+	for _, key := range configKeys {
+		if key.allowedIn(section) {
+			names = append(names, key.Name)
+		}
+	}
+	return names
+}
+
+func isConfigSection(name string) bool {
+	for _, section := range configSections {
+		if name == section {
+			return true
+		}
+	}
+	return false
+}
+
+// validateConfigValue checks the type and range of a single decoded value.
+func validateConfigValue(fullKey string, key ConfigKey, value interface{}) error {
+	switch key.Kind {
+	case kindString, kindDuration:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string, got %v", fullKey, value)
+		}
+	case kindInt:
+		if _, ok := value.(int64); !ok {
+			return fmt.Errorf("%s: expected an integer, got %v", fullKey, value)
+		}
+	case kindBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected true or false, got %v", fullKey, value)
+		}
+	}
+
+	if key.check != nil {
+		if err := key.check(value); err != nil {
+			return fmt.Errorf("%s: %v", fullKey, err)
+		}
+	}
+	return nil
+}
+
+// flattenConfig turns nested tables into dotted keys.
+func flattenConfig(prefix string, table map[string]interface{}, out map[string]interface{}) {
+	for k, v := range table {
+		if sub, ok := v.(map[string]interface{}); ok {
+			flattenConfig(prefix+k+".", sub, out)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+func validateRawConfig(raw map[string]interface{}) error {
+	for name, value := range raw {
+		if isConfigSection(name) {
+			tables, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: expected a table", name)
+			}
+			for sectionName, sub := range tables {
+				table, ok := sub.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("%s.%s: expected a table", name, sectionName)
+				}
+				if err := validateConfigTable(name, name+"."+sectionName+".", table); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := validateConfigTable("", "", map[string]interface{}{name: value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateConfigTable(section, prefix string, table map[string]interface{}) error {
+	flat := make(map[string]interface{})
+	flattenConfig("", table, flat)
+
+	names := make([]string, 0, len(flat))
+	for name := range flat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, ok := lookupConfigKey(name)
+		if !ok || !key.allowedIn(section) {
+			return fmt.Errorf("%s%s: unknown key", prefix, name)
+		}
+		if err := validateConfigValue(prefix+name, key, flat[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetConfigPath returns the location of config.toml, honoring
+// XDG_CONFIG_HOME.
+func GetConfigPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configHome, "snowpass", "config.toml"), nil
+}
+
+func readRawConfig(path string) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return raw, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// LoadConfig reads config.toml on top of the defaults. A missing file is not
+// an error. Validation errors name the offending key.
+func LoadConfig() (models.Config, error) {
+	cfg := models.DefaultConfig()
+
+	path, err := GetConfigPath()
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	raw := make(map[string]interface{})
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateRawConfig(raw); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := toml.Decode(string(data), &cfg); err != nil {
+		return models.DefaultConfig(), fmt.Errorf("%s: %v", path, err)
+	}
+	if cfg.Keystores == nil {
+		cfg.Keystores = make(map[string]models.KeystoreConfig)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]models.ProfileConfig)
+	}
+
+	return cfg, nil
+}
+
+// GetConfigValue returns the effective value of a key, including defaults
+// and keystore or profile overrides.
+func GetConfigValue(cfg models.Config, fullKey string) (string, error) {
+	section, sectionName, key, err := splitConfigKey(fullKey)
+	if err != nil {
+		return "", err
+	}
+	return key.format(applySection(cfg, section, sectionName)), nil
+}
+
+func applySection(cfg models.Config, section, sectionName string) models.Config {
+	switch section {
+	case "keystores":
+		return cfg.ForKeystore(sectionName)
+	case "profiles":
+		return cfg.ForProfile(sectionName)
+	}
+	return cfg
+}
+
+// SetConfigValue validates a value and writes it to config.toml.
+func SetConfigValue(fullKey, value string) error {
+	_, _, key, err := splitConfigKey(fullKey)
+	if err != nil {
+		return err
+	}
+
+	var typed interface{} = value
+	switch key.Kind {
+	case kindInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: expected an integer, got %q", fullKey, value)
+		}
+		typed = n
+	case kindBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: expected true or false, got %q", fullKey, value)
+		}
+		typed = b
+	}
+	if err := validateConfigValue(fullKey, key, typed); err != nil {
+		return err
+	}
+
+	return updateRawConfig(fullKey, func(table map[string]interface{}, name string) {
+		table[name] = typed
+	})
+}
+
+// UnsetConfigValue removes a key so that its default (or the global value
+// for keystore and profile overrides) applies again.
+func UnsetConfigValue(fullKey string) error {
+	return updateRawConfig(fullKey, func(table map[string]interface{}, name string) {
+		delete(table, name)
+	})
+}
+
+// updateRawConfig loads config.toml, calls update with the table holding
+// the key and writes the result back.
+func updateRawConfig(fullKey string, update func(table map[string]interface{}, name string)) error {
+	section, sectionName, key, err := splitConfigKey(fullKey)
+	if err != nil {
+		return err
+	}
+
+	path, err := GetConfigPath()
+	if err != nil {
+		return err
+	}
+	raw, err := readRawConfig(path)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	table := raw
+	if section != "" {
+		table = subTable(subTable(raw, section), sectionName)
+	}
+	parts := strings.Split(key.Name, ".")
+	for _, part := range parts[:len(parts)-1] {
+		table = subTable(table, part)
+	}
+	update(table, parts[len(parts)-1])
+
+	if err := validateRawConfig(raw); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return writeRawConfig(path, raw)
+}
+
+func subTable(table map[string]interface{}, name string) map[string]interface{} {
+	if sub, ok := table[name].(map[string]interface{}); ok {
+		return sub
+	}
+	sub := make(map[string]interface{})
+	table[name] = sub
+	return sub
+}
+
+func writeRawConfig(path string, raw map[string]interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// ListConfigValues returns every effective global value followed by the
+// keys that are explicitly overridden per keystore or profile, in a stable
+// order.
+func ListConfigValues(cfg models.Config) ([][2]string, error) {
+	var values [][2]string
+	for _, key := range configKeys {
+		values = append(values, [2]string{key.Name, key.format(cfg)})
+	}
+
+	path, err := GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := readRawConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, section := range configSections {
+		tables, _ := raw[section].(map[string]interface{})
+		sectionNames := make([]string, 0, len(tables))
+		for name := range tables {
+			sectionNames = append(sectionNames, name)
+		}
+		sort.Strings(sectionNames)
+
+		for _, sectionName := range sectionNames {
+			table, _ := tables[sectionName].(map[string]interface{})
+			flat := make(map[string]interface{})
+			flattenConfig("", table, flat)
+			effective := applySection(cfg, section, sectionName)
+			for _, key := range configKeys {
+				if _, ok := flat[key.Name]; ok {
+					values = append(values, [2]string{section + "." + sectionName + "." + key.Name, key.format(effective)})
+				}
+			}
+		}
+	}
+
+	return values, nil
+}
@@ -5,8 +5,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/99designs/keyring"
+
+	"github.com/fluffysnowman/snowpass/states"
 )
 
 func GetAppDataDir() (string, error) {
@@ -22,7 +25,11 @@ func GetAppDataDir() (string, error) {
 	case "darwin":
 		appDataDir = filepath.Join(homeDir, "Library", "Application Support", "snowpass")
 	case "linux":
-		appDataDir = filepath.Join(homeDir, ".local", "share", "snowpass")
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(homeDir, ".local", "share")
+		}
+		appDataDir = filepath.Join(dataHome, "snowpass")
 	default:
 		return "", fmt.Errorf("unsupported platform\nCreate an issue on https://github.com/fluffysnowman/snowpass with details about this")
 	}
@@ -30,18 +37,41 @@ func GetAppDataDir() (string, error) {
 	return appDataDir, nil
 }
 
+// GetFullDataDir returns the directory holding the keystores, creating it if
+// needed. In order of precedence it comes from --store/SNOWPASS_HOME, the
+// data_dir config key or the platform default. Named profiles get their own
+// directory below the store or the default location.
 func GetFullDataDir() string {
-	appDataDir, err := GetAppDataDir()
-	if err != nil {
-		fmt.Println("Failed to get application data directory:", err)
-		return ""
+	dataDir := states.GlobalConfig.DataDir
+	if states.GlobalStoreOverride != "" {
+		dataDir = states.GlobalStoreOverride
+		if states.GlobalProfile != "" {
+			dataDir = filepath.Join(dataDir, "profiles", states.GlobalProfile)
+		}
 	}
 
-	dataDir := filepath.Join(appDataDir, "_data")
+	if dataDir == "" {
+		appDataDir, err := GetAppDataDir()
+		if err != nil {
+			fmt.Println("Failed to get application data directory:", err)
+			return ""
+		}
+		dataDir = filepath.Join(appDataDir, "_data")
+		if states.GlobalProfile != "" {
+			dataDir = filepath.Join(dataDir, "profiles", states.GlobalProfile)
+		}
+	} else {
+		expanded, err := ExpandHome(dataDir)
+		if err != nil {
+			fmt.Println("Failed to get home directory:", err)
+			return ""
+		}
+		dataDir = expanded
+	}
 
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		fmt.Println("_data directory for keystore does not exist. Creating it now.")
-		if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		if err := os.MkdirAll(dataDir, PrivateDirMode); err != nil {
 			fmt.Println("Failed to create _data directory:", err)
 			return ""
 		}
@@ -50,6 +80,49 @@ func GetFullDataDir() string {
 	return dataDir
 }
 
+// ExpandHome replaces a leading "~/" with the user's home directory.
+func ExpandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, path[2:]), nil
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a crash never leaves a half written keystore or index.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
 var ring keyring.Keyring
 
 func init() {
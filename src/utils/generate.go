@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars  = "0123456789"
+	symbolChars = "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+
+	// characters that are easily confused with each other when read aloud or
+	// typed from a screen
+	ambiguousChars = "Il1|O0o`'\""
+)
+
+// PasswordPolicy controls GeneratePassword. Min* values are only honored for
+// classes that are enabled.
+type PasswordPolicy struct {
+	Length           int
+	Lower            bool
+	Upper            bool
+	Digits           bool
+	Symbols          bool
+	ExcludeAmbiguous bool
+	MinLower         int
+	MinUpper         int
+	MinDigits        int
+	MinSymbols       int
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		Length:     20,
+		Lower:      true,
+		Upper:      true,
+		Digits:     true,
+		Symbols:    true,
+		MinLower:   1,
+		MinUpper:   1,
+		MinDigits:  1,
+		MinSymbols: 1,
+	}
+}
+
+// ParsePasswordPolicy reads a comma separated policy such as
+// "32,no-symbols,no-ambiguous,min-digits=4". An empty spec or "default"
+// gives the default policy; "pin" and "alnum" are shorthands.
+func ParsePasswordPolicy(spec string) (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy()
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		name, value, hasValue := strings.Cut(item, "=")
+
+		number := func() (int, error) {
+			if !hasValue {
+				return 0, fmt.Errorf("%q needs a value, e.g. %s=4", name, name)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("%q must be a non-negative number", name)
+			}
+			return n, nil
+		}
+
+		var err error
+		switch name {
+		case "", "default":
+		case "pin":
+			policy = PasswordPolicy{Length: 6, Digits: true}
+		case "alnum":
+			policy.Symbols = false
+		case "length", "len":
+			policy.Length, err = number()
+		case "no-lower":
+			policy.Lower = false
+		case "no-upper":
+			policy.Upper = false
+		case "no-digits":
+			policy.Digits = false
+		case "no-symbols":
+			policy.Symbols = false
+		case "no-ambiguous":
+			policy.ExcludeAmbiguous = true
+		case "min-lower":
+			policy.MinLower, err = number()
+		case "min-upper":
+			policy.MinUpper, err = number()
+		case "min-digits":
+			policy.MinDigits, err = number()
+		case "min-symbols":
+			policy.MinSymbols, err = number()
+		default:
+			n, convErr := strconv.Atoi(item)
+			if convErr != nil {
+				return policy, fmt.Errorf("unknown policy option %q", item)
+			}
+			policy.Length = n
+		}
+		if err != nil {
+			return policy, err
+		}
+	}
+
+	return policy, nil
+}
+
+type charClass struct {
+	chars   string
+	enabled bool
+	min     int
+}
+
+func (p PasswordPolicy) classes() []charClass {
+	classes := []charClass{
+		{lowerChars, p.Lower, p.MinLower},
+		{upperChars, p.Upper, p.MinUpper},
+		{digitChars, p.Digits, p.MinDigits},
+		{symbolChars, p.Symbols, p.MinSymbols},
+	}
+
+	for i := range classes {
+		if p.ExcludeAmbiguous {
+			classes[i].chars = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(ambiguousChars, r) {
+					return -1
+				}
+				return r
+			}, classes[i].chars)
+		}
+		if !classes[i].enabled {
+			classes[i].min = 0
+		}
+	}
+	return classes
+}
+
+// GeneratePassword returns a random password drawn from crypto/rand that
+// satisfies the policy. The caller destroys it.
+func GeneratePassword(p PasswordPolicy) (*SecureBuffer, error) {
+	if p.Length < 1 {
+		return nil, fmt.Errorf("length must be at least 1")
+	}
+
+	var alphabet string
+	minTotal := 0
+	for _, class := range p.classes() {
+		if class.enabled {
+			alphabet += class.chars
+			minTotal += class.min
+		}
+	}
+	if alphabet == "" {
+		return nil, fmt.Errorf("at least one character class must be enabled")
+	}
+	if minTotal > p.Length {
+		return nil, fmt.Errorf("the minimum character counts (%d) exceed the length (%d)", minTotal, p.Length)
+	}
+
+	buf := NewSecureBuffer(p.Length)
+	password := buf.Bytes()
+	n := 0
+	for _, class := range p.classes() {
+		if !class.enabled {
+			continue
+		}
+		for i := 0; i < class.min; i++ {
+			c, err := randomChar(class.chars)
+			if err != nil {
+				buf.Destroy()
+				return nil, err
+			}
+			password[n] = c
+			n++
+		}
+	}
+	for ; n < p.Length; n++ {
+		c, err := randomChar(alphabet)
+		if err != nil {
+			buf.Destroy()
+			return nil, err
+		}
+		password[n] = c
+	}
+
+	// Fisher-Yates so the required characters don't always come first
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			buf.Destroy()
+			return nil, err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return buf, nil
+}
+
+// PasswordEntropy estimates the entropy in bits of a password generated from
+// the policy, ignoring the small reduction caused by the minimum counts.
+func PasswordEntropy(p PasswordPolicy) float64 {
+	size := 0
+	for _, class := range p.classes() {
+		if class.enabled {
+			size += len(class.chars)
+		}
+	}
+	if size == 0 {
+		return 0
+	}
+	return float64(p.Length) * math.Log2(float64(size))
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
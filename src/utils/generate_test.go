@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// countIn counts the characters of password that are in chars.
+func countIn(password []byte, chars string) int {
+	n := 0
+	for _, c := range password {
+		if strings.IndexByte(chars, c) >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGeneratePassword(t *testing.T) {
+	cases := []struct {
+		spec   string
+		length int
+		// characters that must not appear
+		excluded string
+		// characters of which at least min must appear
+		required map[string]int
+	}{
+		{"", 20, "", map[string]int{lowerChars: 1, upperChars: 1, digitChars: 1, symbolChars: 1}},
+		{"1,min-lower=0,min-upper=0,min-digits=0,min-symbols=0", 1, "", nil},
+		{"4", 4, "", map[string]int{lowerChars: 1, upperChars: 1, digitChars: 1, symbolChars: 1}},
+		{"64", 64, "", map[string]int{lowerChars: 1, upperChars: 1, digitChars: 1, symbolChars: 1}},
+		{"pin", 6, lowerChars + upperChars + symbolChars, nil},
+		{"pin,length=12", 12, lowerChars + upperChars + symbolChars, nil},
+		{"alnum", 20, symbolChars, map[string]int{lowerChars: 1, upperChars: 1, digitChars: 1}},
+		{"32,no-symbols,no-digits", 32, symbolChars + digitChars, map[string]int{lowerChars: 1, upperChars: 1}},
+		{"16,no-lower,no-upper,no-symbols", 16, lowerChars + upperChars + symbolChars, nil},
+		{"24,no-ambiguous", 24, ambiguousChars, map[string]int{lowerChars: 1, upperChars: 1, digitChars: 1, symbolChars: 1}},
+		{"12,min-digits=4,min-symbols=3", 12, "", map[string]int{digitChars: 4, symbolChars: 3}},
+		{"8,min-lower=2,min-upper=2,min-digits=2,min-symbols=2", 8, "", map[string]int{lowerChars: 2, upperChars: 2, digitChars: 2, symbolChars: 2}},
+		// a minimum of a disabled class is ignored
+		{"10,no-symbols,min-symbols=5", 10, symbolChars, nil},
+	}
+	for _, c := range cases {
+		policy, err := ParsePasswordPolicy(c.spec)
+		if err != nil {
+			t.Fatalf("ParsePasswordPolicy(%q): %v", c.spec, err)
+		}
+		// random output, so every policy is tried a number of times
+		for i := 0; i < 200; i++ {
+			buf, err := GeneratePassword(policy)
+			if err != nil {
+				t.Fatalf("GeneratePassword(%q): %v", c.spec, err)
+			}
+			password := buf.Bytes()
+			if len(password) != c.length {
+				t.Errorf("GeneratePassword(%q) has %d characters, want %d", c.spec, len(password), c.length)
+			}
+			if n := countIn(password, lowerChars+upperChars+digitChars+symbolChars); n != len(password) {
+				t.Errorf("GeneratePassword(%q) = %q has characters outside every class", c.spec, password)
+			}
+			if c.excluded != "" && countIn(password, c.excluded) > 0 {
+				t.Errorf("GeneratePassword(%q) = %q contains one of %q", c.spec, password, c.excluded)
+			}
+			for chars, min := range c.required {
+				if n := countIn(password, chars); n < min {
+					t.Errorf("GeneratePassword(%q) = %q has %d of %q, want at least %d", c.spec, password, n, chars, min)
+				}
+			}
+			buf.Destroy()
+		}
+	}
+}
+
+func TestGeneratePasswordRejects(t *testing.T) {
+	impossible := []string{
+		"0",
+		"-5",
+		"length=0",
+		"no-lower,no-upper,no-digits,no-symbols",
+		"3",
+		"10,min-digits=11",
+		"8,min-lower=2,min-upper=2,min-digits=2,min-symbols=3",
+		"pin,min-digits=7",
+	}
+	for _, spec := range impossible {
+		policy, err := ParsePasswordPolicy(spec)
+		if err != nil {
+			t.Fatalf("ParsePasswordPolicy(%q): %v", spec, err)
+		}
+		if buf, err := GeneratePassword(policy); err == nil {
+			t.Errorf("GeneratePassword(%q) = %q, want an error", spec, buf.Bytes())
+			buf.Destroy()
+		}
+	}
+}
+
+func TestParsePasswordPolicyRejects(t *testing.T) {
+	for _, spec := range []string{
+		"bogus",
+		"32,bogus",
+		"min-digits",
+		"min-digits=-1",
+		"min-digits=x",
+		"length=",
+	} {
+		if _, err := ParsePasswordPolicy(spec); err == nil {
+			t.Errorf("ParsePasswordPolicy(%q): want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker looks up passwords in a local copy of the Have I Been Pwned
+// SHA-1 list. The source is either the single file ordered by hash
+// ("HASH:COUNT" per line) or a directory of range files named after the first
+// five hex characters of the hash ("SUFFIX:COUNT" per line), as downloaded by
+// the official downloader. Nothing is ever sent over the network.
+type BreachChecker struct {
+	path  string
+	isDir bool
+}
+
+func NewBreachChecker(path string) (*BreachChecker, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no HIBP dataset configured (set hibp.path or pass --hibp)")
+	}
+
+	path, err := ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BreachChecker{path: path, isDir: info.IsDir()}, nil
+}
+
+// Count returns how often the password appears in the dataset, 0 if it does
+// not appear at all.
+func (b *BreachChecker) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if b.isDir {
+		return b.countInRangeFile(hash)
+	}
+	return b.countInSortedFile(hash)
+}
+
+func (b *BreachChecker) countInRangeFile(hash string) (int, error) {
+	prefix, suffix := hash[:5], hash[5:]
+
+	data, err := os.ReadFile(filepath.Join(b.path, prefix))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(filepath.Join(b.path, prefix+".txt"))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("missing range file for %s: %v", prefix, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(data), "\r", "")), "\n")
+	i := sort.Search(len(lines), func(i int) bool {
+		return strings.ToUpper(lines[i]) >= suffix
+	})
+	if i < len(lines) && strings.HasPrefix(strings.ToUpper(lines[i]), suffix+":") {
+		return parseBreachCount(lines[i])
+	}
+	return 0, nil
+}
+
+// countInSortedFile binary searches the byte offsets of the multi-gigabyte
+// sorted file instead of reading it.
+func (b *BreachChecker) countInSortedFile(hash string) (int, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	// find the smallest offset whose following line is >= hash
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := lineAtOrAfter(f, mid, size)
+		if err != nil {
+			return 0, err
+		}
+		// "HASH:COUNT" sorts after HASH itself, so whole lines can be compared
+		if line != "" && strings.ToUpper(line) < hash {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	line, err := lineAtOrAfter(f, lo, size)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(strings.ToUpper(line), hash+":") {
+		return parseBreachCount(line)
+	}
+	return 0, nil
+}
+
+// lineAtOrAfter returns the first complete line that starts at or after
+// offset, or "" at the end of the file.
+func lineAtOrAfter(f *os.File, offset, size int64) (string, error) {
+	start := offset
+	if offset > 0 {
+		// a line starts at offset only if the previous byte is a newline
+		buf := make([]byte, 128)
+		for pos := offset - 1; ; pos += int64(len(buf)) {
+			if pos >= size {
+				return "", nil
+			}
+			n, err := f.ReadAt(buf, pos)
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+				start = pos + int64(i) + 1
+				break
+			}
+			if err == io.EOF {
+				return "", nil
+			}
+		}
+	}
+	if start >= size {
+		return "", nil
+	}
+
+	buf := make([]byte, 128)
+	n, err := f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line := string(buf[:n])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimRight(line, "\r"), nil
+}
+
+func parseBreachCount(line string) (int, error) {
+	_, count, found := strings.Cut(strings.TrimSpace(line), ":")
+	if !found {
+		return 0, fmt.Errorf("malformed HIBP line %q", line)
+	}
+	return strconv.Atoi(count)
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type breachPassword struct {
+	password, hash string
+	count          int
+}
+
+// breachDataset returns passwords sorted by hash. The first, the last and
+// every tenth are left out of the dataset, the others appear with a count.
+func breachDataset() (present, absent []breachPassword) {
+	var all []breachPassword
+	for i := 0; i < 400; i++ {
+		password := fmt.Sprintf("password-%d", i)
+		sum := sha1.Sum([]byte(password))
+		all = append(all, breachPassword{password, strings.ToUpper(hex.EncodeToString(sum[:])), 0})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].hash < all[j].hash })
+	for i, p := range all {
+		if i == 0 || i == len(all)-1 || i%10 == 5 {
+			absent = append(absent, p)
+			continue
+		}
+		p.count = i*7919 + 1
+		present = append(present, p)
+	}
+	present[len(present)/2].count = 3861493
+	return present, absent
+}
+
+func writeSortedFile(t *testing.T, present []breachPassword, newline string, trailing bool) string {
+	lines := make([]string, len(present))
+	for i, p := range present {
+		lines[i] = fmt.Sprintf("%s:%d", p.hash, p.count)
+	}
+	data := strings.Join(lines, newline)
+	if trailing {
+		data += newline
+	}
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkBreachCounts(t *testing.T, name string, checker *BreachChecker, present, absent []breachPassword) {
+	t.Helper()
+	for _, p := range present {
+		got, err := checker.Count(p.password)
+		if err != nil {
+			t.Errorf("%s: Count(%q): %v", name, p.password, err)
+		} else if got != p.count {
+			t.Errorf("%s: Count(%q) = %d, want %d", name, p.password, got, p.count)
+		}
+	}
+	for _, p := range absent {
+		if got, err := checker.Count(p.password); err != nil || got != 0 {
+			t.Errorf("%s: Count(%q) = %d, %v, want 0", name, p.password, got, err)
+		}
+	}
+}
+
+func TestBreachCheckerSortedFile(t *testing.T) {
+	present, absent := breachDataset()
+	cases := []struct {
+		name     string
+		newline  string
+		trailing bool
+	}{
+		{"LF", "\n", true},
+		{"LF without trailing newline", "\n", false},
+		{"CRLF", "\r\n", true},
+		{"CRLF without trailing newline", "\r\n", false},
+	}
+	for _, c := range cases {
+		checker, err := NewBreachChecker(writeSortedFile(t, present, c.newline, c.trailing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the hashes on the first and the last line are in present
+		checkBreachCounts(t, c.name, checker, present, absent)
+	}
+}
+
+func TestBreachCheckerSortedFileEdges(t *testing.T) {
+	present, absent := breachDataset()
+	for _, c := range []struct {
+		name    string
+		present []breachPassword
+	}{
+		{"one line", present[:1]},
+		{"two lines", present[:2]},
+		{"empty", nil},
+	} {
+		checker, err := NewBreachChecker(writeSortedFile(t, c.present, "\n", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkBreachCounts(t, c.name, checker, c.present, append(absent, present[2:]...))
+	}
+}
+
+func TestBreachCheckerRangeFiles(t *testing.T) {
+	present, absent := breachDataset()
+	dir := t.TempDir()
+
+	// every range file holds a few made up suffixes around the real ones,
+	// so that the search has something to skip
+	ranges := make(map[string][]string)
+	for _, p := range present {
+		prefix := p.hash[:5]
+		ranges[prefix] = append(ranges[prefix], fmt.Sprintf("%s:%d", p.hash[5:], p.count))
+	}
+	for _, p := range absent {
+		ranges[p.hash[:5]] = append(ranges[p.hash[:5]], "")
+	}
+	i := 0
+	for prefix, lines := range ranges {
+		var filled []string
+		for _, line := range lines {
+			if line != "" {
+				filled = append(filled, line)
+			}
+		}
+		filled = append(filled, strings.Repeat("0", 35)+":1", strings.Repeat("F", 35)+":2", "8"+strings.Repeat("A", 34)+":3")
+		sort.Strings(filled)
+
+		// alternate the layouts of the downloader's versions
+		name, newline := prefix, "\n"
+		switch i % 3 {
+		case 1:
+			name = prefix + ".txt"
+		case 2:
+			newline = "\r\n"
+		}
+		data := strings.Join(filled, newline)
+		if i%2 == 0 {
+			data += newline
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		i++
+	}
+
+	checker, err := NewBreachChecker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBreachCounts(t, "range files", checker, present, absent)
+
+	// a range file that is missing is an error, not a clean result
+	if _, err := checker.Count("not in any range file, hopefully"); err == nil {
+		t.Error("Count with a missing range file: want an error")
+	}
+}
+
+func TestParseBreachCount(t *testing.T) {
+	accepted := map[string]int{
+		"0018A45C4D1DEF81644B54AB7F969B88D65:1":         1,
+		"0018A45C4D1DEF81644B54AB7F969B88D65:3861493":   3861493,
+		"0018A45C4D1DEF81644B54AB7F969B88D65:10\r":      10,
+		"  0018A45C4D1DEF81644B54AB7F969B88D65:42  ":    42,
+		"7C4A8D09CA3762AF61E59520943DC26494F8941B:0":    0,
+		"7c4a8d09ca3762af61e59520943dc26494f8941b:1234": 1234,
+	}
+	for line, want := range accepted {
+		got, err := parseBreachCount(line)
+		if err != nil {
+			t.Errorf("parseBreachCount(%q): %v", line, err)
+		} else if got != want {
+			t.Errorf("parseBreachCount(%q) = %d, want %d", line, got, want)
+		}
+	}
+
+	for _, line := range []string{
+		"0018A45C4D1DEF81644B54AB7F969B88D65",
+		"0018A45C4D1DEF81644B54AB7F969B88D65:",
+		"0018A45C4D1DEF81644B54AB7F969B88D65:12x",
+		"0018A45C4D1DEF81644B54AB7F969B88D65:1:2",
+	} {
+		if got, err := parseBreachCount(line); err == nil {
+			t.Errorf("parseBreachCount(%q) = %d, want an error", line, got)
+		}
+	}
+}
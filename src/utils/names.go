@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MaxKeystoreNameLength = 64
+	MaxIdentifierLength   = 128
+)
+
+// reservedKeystoreNames are taken by other files in the data directory, or
+// cannot be used as file names on Windows.
+var reservedKeystoreNames = map[string]bool{
+	"profiles": true,
+	"con":      true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// NormalizeKeystoreName returns the keystore name in Unicode NFC, so that
+// "café" typed on macOS and on Linux is the same keystore, or an error if it
+// cannot be used. Keystore names become file names, so they are limited to
+// letters, digits, '-', '_' and '.', do not start with a dot or dash and do
+// not end in "_index", which is the suffix of the index files.
+func NormalizeKeystoreName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("keystore name is not valid UTF-8")
+	}
+	name = norm.NFC.String(name)
+
+	switch {
+	case name == "":
+		return "", fmt.Errorf("keystore name must not be empty")
+	case utf8.RuneCountInString(name) > MaxKeystoreNameLength:
+		return "", fmt.Errorf("keystore name must be at most %d characters", MaxKeystoreNameLength)
+	case strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-"):
+		return "", fmt.Errorf("keystore name %q must not start with %q", name, name[:1])
+	case strings.HasSuffix(strings.ToLower(name), "_index"):
+		return "", fmt.Errorf("keystore name %q must not end in \"_index\"", name)
+	case strings.HasSuffix(name, "."):
+		return "", fmt.Errorf("keystore name %q must not end with a dot", name)
+	}
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return "", fmt.Errorf("keystore name %q contains %q, only letters, digits, '-', '_' and '.' are allowed", name, r)
+		}
+	}
+
+	base, _, _ := strings.Cut(strings.ToLower(name), ".")
+	if reservedKeystoreNames[base] {
+		return "", fmt.Errorf("keystore name %q is reserved", name)
+	}
+	return name, nil
+}
+
+// NormalizeIdentifier returns the identifier in Unicode NFC, or an error if
+// it is empty, too long, has control or invisible format characters, or
+// leading or trailing white space.
+func NormalizeIdentifier(identifier string) (string, error) {
+	if !utf8.ValidString(identifier) {
+		return "", fmt.Errorf("identifier is not valid UTF-8")
+	}
+	identifier = norm.NFC.String(identifier)
+
+	switch {
+	case identifier == "":
+		return "", fmt.Errorf("identifier must not be empty")
+	case utf8.RuneCountInString(identifier) > MaxIdentifierLength:
+		return "", fmt.Errorf("identifier must be at most %d characters", MaxIdentifierLength)
+	case strings.TrimSpace(identifier) != identifier:
+		return "", fmt.Errorf("identifier %q must not start or end with white space", identifier)
+	}
+
+	for _, r := range identifier {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return "", fmt.Errorf("identifier %q contains the invisible character %U", identifier, r)
+		}
+	}
+	return identifier, nil
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeKeystoreNameAccepts(t *testing.T) {
+	accepted := map[string]string{
+		"work":             "work",
+		"work-2024_v1.bak": "work-2024_v1.bak",
+		"cafe\u0301":       "café", // decomposed é becomes NFC
+		"con2":             "con2",
+		"console":          "console",
+		strings.Repeat("a", MaxKeystoreNameLength): strings.Repeat("a", MaxKeystoreNameLength),
+		strings.Repeat("é", MaxKeystoreNameLength): strings.Repeat("é", MaxKeystoreNameLength),
+	}
+	for name, want := range accepted {
+		got, err := NormalizeKeystoreName(name)
+		if err != nil {
+			t.Errorf("NormalizeKeystoreName(%q): %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("NormalizeKeystoreName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNormalizeKeystoreNameRejects(t *testing.T) {
+	rejected := []string{
+		// empty and over-long
+		"",
+		strings.Repeat("a", MaxKeystoreNameLength+1),
+		// path traversal and separators
+		".",
+		"..",
+		"../work",
+		"work/../home",
+		"a/b",
+		`a\b`,
+		"/etc/passwd",
+		"C:work",
+		// NUL and control characters
+		"work\x00",
+		"wo\x00rk",
+		"work\n",
+		"work\t2",
+		"work\x1b[31m",
+		"work\u200b", // zero width space
+		"\xff\xfe",   // not UTF-8
+		// reserved names, in any case and with an extension
+		"profiles",
+		"con",
+		"CON",
+		"nul.json",
+		"Aux.txt",
+		"prn",
+		"com1",
+		"LPT9",
+		// leading dots and dashes
+		".hidden",
+		"-rf",
+		"--help",
+		// trailing dots and the index suffix
+		"work.",
+		"work_index",
+		"work_INDEX",
+		// other characters that are not letters or digits
+		"my work",
+		"work*",
+		"work?",
+		"work:2",
+		"a|b",
+	}
+	for _, name := range rejected {
+		if got, err := NormalizeKeystoreName(name); err == nil {
+			t.Errorf("NormalizeKeystoreName(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestNormalizeIdentifierAccepts(t *testing.T) {
+	accepted := map[string]string{
+		"db_password":                            "db_password",
+		"github.com":                             "github.com",
+		"aws/prod/root":                          "aws/prod/root", // identifiers are not file names
+		"..":                                     "..",
+		"my login":                               "my login",
+		"cafe\u0301":                             "café",
+		strings.Repeat("x", MaxIdentifierLength): strings.Repeat("x", MaxIdentifierLength),
+	}
+	for identifier, want := range accepted {
+		got, err := NormalizeIdentifier(identifier)
+		if err != nil {
+			t.Errorf("NormalizeIdentifier(%q): %v", identifier, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("NormalizeIdentifier(%q) = %q, want %q", identifier, got, want)
+		}
+	}
+}
+
+func TestNormalizeIdentifierRejects(t *testing.T) {
+	rejected := []string{
+		"",
+		strings.Repeat("x", MaxIdentifierLength+1),
+		"db\x00",
+		"d\x00b",
+		"db\r\npassword",
+		"db\x7f",
+		"db\u200e", // left-to-right mark
+		"\ufeffdb", // byte order mark
+		" db",
+		"db ",
+		"\tdb",
+		"\xc3\x28",
+	}
+	for _, identifier := range rejected {
+		if got, err := NormalizeIdentifier(identifier); err == nil {
+			t.Errorf("NormalizeIdentifier(%q) = %q, want an error", identifier, got)
+		}
+	}
+}
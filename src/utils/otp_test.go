@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 4226 appendix D
+func TestHOTPVectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range want {
+		got, err := HOTP(secret, uint64(counter), 6, "SHA1")
+		if err != nil {
+			t.Fatalf("HOTP(%d): %v", counter, err)
+		}
+		if got != code {
+			t.Errorf("HOTP(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238 appendix B
+func TestTOTPVectors(t *testing.T) {
+	secrets := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	vectors := []struct {
+		unix int64
+		want map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+	for _, v := range vectors {
+		for algorithm, code := range v.want {
+			got, remaining, err := TOTP(secrets[algorithm], time.Unix(v.unix, 0), 30, 8, algorithm)
+			if err != nil {
+				t.Fatalf("TOTP(%d, %s): %v", v.unix, algorithm, err)
+			}
+			if got != code {
+				t.Errorf("TOTP(%d, %s) = %s, want %s", v.unix, algorithm, got, code)
+			}
+			if want := 30 - int(v.unix%30); remaining != want {
+				t.Errorf("TOTP(%d, %s) stays valid for %d seconds, want %d", v.unix, algorithm, remaining, want)
+			}
+		}
+	}
+}
+
+func TestParseOTPSecret(t *testing.T) {
+	params, err := ParseOTPSecret("otpauth://hotp/Example:alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Example&counter=7&digits=8&algorithm=sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Type != "hotp" || params.Counter != 7 || params.Digits != 8 || params.Algorithm != "SHA256" || params.Issuer != "Example" {
+		t.Errorf("unexpected parameters %+v", params)
+	}
+	if string(params.Secret) != "12345678901234567890" {
+		t.Errorf("secret = %q", params.Secret)
+	}
+
+	again, err := ParseOTPSecret(params.URI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.URI() != params.URI() {
+		t.Errorf("URI does not round trip: %s != %s", again.URI(), params.URI())
+	}
+
+	bare, err := ParseOTPSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bare.Type != "totp" || bare.Period != 30 || bare.Digits != 6 || string(bare.Secret) != "12345678901234567890" {
+		t.Errorf("unexpected parameters for a bare secret %+v", bare)
+	}
+
+	for _, bad := range []string{"", "not base32!", "otpauth://hotp/x?secret=GEZDGNBV", "otpauth://totp/x?secret=GEZDGNBV&algorithm=MD5"} {
+		if _, err := ParseOTPSecret(bad); err == nil {
+			t.Errorf("ParseOTPSecret(%q) succeeded", bad)
+		}
+	}
+}
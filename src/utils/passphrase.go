@@ -0,0 +1,83 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// effLargeWordlist is the EFF large wordlist for five dice
+// (https://www.eff.org/files/2016/07/18/eff_large_wordlist.txt).
+//
+//go:embed wordlists/eff_large_wordlist.txt
+var effLargeWordlist string
+
+var dicewareWords []string
+
+func getDicewareWords() []string {
+	if dicewareWords != nil {
+		return dicewareWords
+	}
+
+	for _, line := range strings.Split(effLargeWordlist, "\n") {
+		// each line is "<dice rolls>\t<word>"
+		if _, word, found := strings.Cut(line, "\t"); found {
+			dicewareWords = append(dicewareWords, strings.TrimSpace(word))
+		}
+	}
+	return dicewareWords
+}
+
+type PassphraseOptions struct {
+	Words       int
+	Separator   string
+	Capitalize  bool
+	AppendDigit bool
+}
+
+func DefaultPassphraseOptions() PassphraseOptions {
+	return PassphraseOptions{
+		Words:     6,
+		Separator: "-",
+	}
+}
+
+// GeneratePassphrase picks words uniformly from the EFF large wordlist using
+// crypto/rand and returns the passphrase with its entropy in bits.
+func GeneratePassphrase(opts PassphraseOptions) (string, float64, error) {
+	if opts.Words < 1 {
+		return "", 0, fmt.Errorf("word count must be at least 1")
+	}
+
+	words := getDicewareWords()
+	picked := make([]string, opts.Words)
+	for i := range picked {
+		n, err := randomInt(len(words))
+		if err != nil {
+			return "", 0, err
+		}
+		word := words[n]
+		if opts.Capitalize {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			word = string(runes)
+		}
+		picked[i] = word
+	}
+
+	entropy := float64(opts.Words) * math.Log2(float64(len(words)))
+	passphrase := strings.Join(picked, opts.Separator)
+
+	if opts.AppendDigit {
+		digit, err := randomInt(10)
+		if err != nil {
+			return "", 0, err
+		}
+		passphrase += fmt.Sprint(digit)
+		entropy += math.Log2(10)
+	}
+
+	return passphrase, entropy, nil
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Keystores, indexes and everything else snowpass writes are private to the
+// user running it.
+const (
+	PrivateFileMode os.FileMode = 0600
+	PrivateDirMode  os.FileMode = 0700
+)
+
+// PermissionProblem describes a file that other users can get at.
+type PermissionProblem struct {
+	Path   string
+	Mode   os.FileMode
+	Reason string
+	// Fixable is false for problems chmod cannot solve, like a file owned
+	// by someone else.
+	Fixable bool
+}
+
+func (p PermissionProblem) String() string {
+	return fmt.Sprintf("%s (%04o): %s", p.Path, p.Mode.Perm(), p.Reason)
+}
+
+// PrivatePaths returns the data directory, the config file and the identity
+// file, the paths whose permissions are checked and fixed.
+func PrivatePaths(dataDir string) []string {
+	paths := []string{dataDir}
+	if configPath, err := GetConfigPath(); err == nil {
+		paths = append(paths, configPath)
+	}
+	if identityPath, err := GetIdentityPath(); err == nil {
+		paths = append(paths, identityPath)
+	}
+	return paths
+}
+
+// CheckPermissions reports files and directories below paths that are
+// readable or writable by other users, or not owned by the current user.
+// Paths that do not exist are skipped.
+func CheckPermissions(paths []string) []PermissionProblem {
+	if !permissionsSupported {
+		return nil
+	}
+
+	var problems []PermissionProblem
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+
+			if !ownedByCurrentUser(info) {
+				problems = append(problems, PermissionProblem{Path: path, Mode: info.Mode(), Reason: "owned by another user"})
+			}
+			if info.Mode().Perm()&0077 != 0 {
+				reason := "accessible by other users"
+				if info.IsDir() {
+					reason = "directory accessible by other users"
+				}
+				problems = append(problems, PermissionProblem{Path: path, Mode: info.Mode(), Reason: reason, Fixable: true})
+			}
+			return nil
+		})
+	}
+	return problems
+}
+
+// FixPermissions restricts the fixable problems to the owner, files to 0600
+// and directories to 0700. It returns the problems it could not fix.
+func FixPermissions(problems []PermissionProblem) []PermissionProblem {
+	var remaining []PermissionProblem
+	for _, problem := range problems {
+		if !problem.Fixable {
+			remaining = append(remaining, problem)
+			continue
+		}
+		mode := PrivateFileMode
+		if problem.Mode.IsDir() {
+			mode = PrivateDirMode
+		}
+		if err := os.Chmod(problem.Path, mode); err != nil {
+			problem.Reason = err.Error()
+			problem.Fixable = false
+			remaining = append(remaining, problem)
+		}
+	}
+	return remaining
+}
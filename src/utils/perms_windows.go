@@ -0,0 +1,11 @@
+package utils
+
+import "os"
+
+// Windows uses ACLs instead of mode bits, the user profile is private by
+// default.
+const permissionsSupported = false
+
+func ownedByCurrentUser(info os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+// GenerateRecoveryKey returns 160 random bits as base32 in groups of four,
+// e.g. "K3QZ-7HMA-...", which is easy to print and type back in.
+func GenerateRecoveryKey() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	groups := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// NormalizeRecoveryKey undoes the grouping and case of a typed recovery key.
+func NormalizeRecoveryKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(key))
+}
+
+// NormalizeSecureRecoveryKey is NormalizeRecoveryKey into a SecureBuffer,
+// for keys that should not be copied onto the heap. Recovery keys are
+// base32, so only ASCII letters are upper-cased.
+func NormalizeSecureRecoveryKey(key string) *SecureBuffer {
+	n := 0
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c != '-' && c != ' ' && c != '\t' {
+			n++
+		}
+	}
+	buf := NewSecureBuffer(n)
+	out := buf.Bytes()
+	n = 0
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c == '-' || c == ' ' || c == '\t':
+		case 'a' <= c && c <= 'z':
+			out[n] = c - 'a' + 'A'
+			n++
+		default:
+			out[n] = c
+			n++
+		}
+	}
+	return buf
+}
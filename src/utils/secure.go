@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"crypto/rand"
+	"sync"
+	"unsafe"
+)
+
+// SecureBuffer holds a secret such as a derived key or the data key. Where
+// the platform allows it the memory lives outside the Go heap, is locked so
+// it is never swapped out and is left out of core dumps. Destroy wipes it;
+// DestroySecrets does that for every buffer still alive before exiting.
+type SecureBuffer struct {
+	data   []byte
+	mapped bool
+}
+
+var (
+	secretsMu sync.Mutex
+	secrets   = make(map[*SecureBuffer]struct{})
+)
+
+// NewSecureBuffer returns a zeroed buffer of size bytes.
+func NewSecureBuffer(size int) *SecureBuffer {
+	buf := &SecureBuffer{}
+	buf.data, buf.mapped = allocSecure(size)
+
+	secretsMu.Lock()
+	secrets[buf] = struct{}{}
+	secretsMu.Unlock()
+	return buf
+}
+
+// SecureBytes moves b into a new buffer and wipes b.
+func SecureBytes(b []byte) *SecureBuffer {
+	buf := NewSecureBuffer(len(b))
+	copy(buf.data, b)
+	Wipe(b)
+	return buf
+}
+
+// RandomSecureBuffer returns a buffer of size random bytes.
+func RandomSecureBuffer(size int) (*SecureBuffer, error) {
+	buf := NewSecureBuffer(size)
+	if _, err := rand.Read(buf.data); err != nil {
+		buf.Destroy()
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Bytes returns the secret. The slice is only valid until Destroy.
+func (b *SecureBuffer) Bytes() []byte {
+	return b.data
+}
+
+// String returns the secret as a string that shares its memory, for APIs
+// that only take strings, without leaving a copy on the heap.
+//
+// The string is NOT a copy. Once the buffer is destroyed it points at
+// wiped or, on Linux, unmapped memory: reading it then returns zeros or
+// crashes the process. Only pass it to calls that are done with it when
+// they return, never store it, and destroy the buffer after those calls.
+func (b *SecureBuffer) String() string {
+	return AliasString(b.data)
+}
+
+// AliasString returns b as a string that shares its memory, with the same
+// caveats as SecureBuffer.String: it is only valid as long as b is, and
+// changes to b show through.
+func AliasString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// Truncate returns the first n bytes of the secret in a buffer of their
+// own and destroys b.
+func (b *SecureBuffer) Truncate(n int) *SecureBuffer {
+	if n == len(b.data) {
+		return b
+	}
+	out := NewSecureBuffer(n)
+	copy(out.data, b.data)
+	b.Destroy()
+	return out
+}
+
+// Destroy wipes the secret and releases its memory. It is safe to call more
+// than once.
+func (b *SecureBuffer) Destroy() {
+	secretsMu.Lock()
+	delete(secrets, b)
+	secretsMu.Unlock()
+
+	if b.data == nil {
+		return
+	}
+	Wipe(b.data)
+	if b.mapped {
+		freeSecure(b.data)
+	}
+	b.data = nil
+}
+
+// DestroySecrets wipes every buffer that has not been destroyed yet.
+func DestroySecrets() {
+	secretsMu.Lock()
+	alive := make([]*SecureBuffer, 0, len(secrets))
+	for buf := range secrets {
+		alive = append(alive, buf)
+	}
+	secretsMu.Unlock()
+
+	for _, buf := range alive {
+		buf.Destroy()
+	}
+}
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
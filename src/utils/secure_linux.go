@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"syscall"
+)
+
+// allocSecure maps size bytes of anonymous memory for a SecureBuffer. The
+// mapping is page aligned, so locking and unlocking it never affects other
+// data. Locking fails silently when RLIMIT_MEMLOCK is exhausted; the buffer
+// is still wiped on Destroy.
+func allocSecure(size int) ([]byte, bool) {
+	if size == 0 {
+		return []byte{}, false
+	}
+	data, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return make([]byte, size), false
+	}
+	syscall.Mlock(data)
+	syscall.Madvise(data, madvDontDump)
+	return data, true
+}
+
+func freeSecure(data []byte) {
+	syscall.Munlock(data)
+	syscall.Munmap(data)
+}
+
+// MADV_DONTDUMP, which the syscall package does not define.
+const madvDontDump = 0x10
+
+// DisableCoreDumps keeps secrets of this process out of core dumps and
+// stops other processes of the same user from attaching to it.
+func DisableCoreDumps() error {
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: 0, Max: 0}); err != nil {
+		return err
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_DUMPABLE, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package utils
+
+// allocSecure falls back to the Go heap. The buffer is still wiped on
+// Destroy, but may have been swapped out before.
+func allocSecure(size int) ([]byte, bool) {
+	return make([]byte, size), false
+}
+
+func freeSecure(data []byte) {}
+
+// DisableCoreDumps is only implemented on Linux.
+func DisableCoreDumps() error {
+	return nil
+}
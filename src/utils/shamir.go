@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+
+	"rsc.io/qr/gf256"
+)
+
+// Shamir's secret sharing over GF(2^8), one polynomial per byte of the
+// secret. Fewer than threshold shares are consistent with every possible
+// secret, so they reveal nothing about it.
+var shamirField = gf256.NewField(0x11b, 0x03)
+
+// SplitSecret splits secret into n shares of which any threshold recover it.
+// Share i is the x coordinate i+1 followed by the polynomial values.
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("need 2 <= threshold <= shares <= 255")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			// Horner's rule, highest coefficient first
+			x, y := share[0], byte(0)
+			for j := threshold - 1; j >= 0; j-- {
+				y = shamirField.Add(shamirField.Mul(y, x), coefficients[j])
+			}
+			share[1+b] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares recovers the secret from at least threshold shares made by
+// SplitSecret. With fewer shares the result is garbage, not an error.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("need at least two shares")
+	}
+	size := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("duplicate or invalid share")
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// Lagrange basis polynomial of share i evaluated at 0; in GF(2^8)
+		// subtraction is the same as addition
+		basis := byte(1)
+		for j, other := range shares {
+			if i == j {
+				continue
+			}
+			basis = shamirField.Mul(basis, shamirField.Mul(other[0], shamirField.Inv(shamirField.Add(other[0], share[0]))))
+		}
+		for b := range secret {
+			secret[b] = shamirField.Add(secret[b], shamirField.Mul(share[1+b], basis))
+		}
+	}
+
+	return secret, nil
+}
+
+// Share is one decoded share string.
+type Share struct {
+	Set       []byte // identifies the split the share belongs to
+	Threshold int
+	Data      []byte // x coordinate followed by the values
+}
+
+const shareVersion = 1
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EncodeShare renders a share as "SPS-XXXX-XXXX-...", with the version, the
+// set, the threshold and a checksum included so that shares can be checked
+// as they are typed in.
+func EncodeShare(share Share) string {
+	payload := []byte{shareVersion}
+	payload = append(payload, share.Set...)
+	payload = append(payload, byte(share.Threshold))
+	payload = append(payload, share.Data...)
+	sum := sha256.Sum256(payload)
+	payload = append(payload, sum[:4]...)
+
+	encoded := shareEncoding.EncodeToString(payload)
+	groups := []string{"SPS"}
+	for i := 0; i < len(encoded); i += 4 {
+		end := i + 4
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		groups = append(groups, encoded[i:end])
+	}
+	return strings.Join(groups, "-")
+}
+
+// DecodeShare parses a share string made by EncodeShare. Case, dashes and
+// spaces do not matter.
+func DecodeShare(text string) (Share, error) {
+	text = NormalizeRecoveryKey(text)
+	if !strings.HasPrefix(text, "SPS") {
+		return Share{}, fmt.Errorf("not a snowpass share")
+	}
+
+	payload, err := shareEncoding.DecodeString(strings.TrimPrefix(text, "SPS"))
+	if err != nil {
+		return Share{}, fmt.Errorf("share is not valid base32")
+	}
+	// version, 4 byte set, threshold, x, at least one value, checksum
+	if len(payload) < 1+4+1+2+4 {
+		return Share{}, fmt.Errorf("share is too short")
+	}
+
+	body, checksum := payload[:len(payload)-4], payload[len(payload)-4:]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:4], checksum) {
+		return Share{}, fmt.Errorf("share checksum does not match, check for typos")
+	}
+	if body[0] != shareVersion {
+		return Share{}, fmt.Errorf("unsupported share version %d", body[0])
+	}
+
+	return Share{
+		Set:       body[1:5],
+		Threshold: int(body[5]),
+		Data:      body[6:],
+	}, nil
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/bits"
+	"strings"
+	"testing"
+)
+
+// subsets returns every subset of shares of the given size.
+func subsets(shares [][]byte, size int) [][][]byte {
+	var result [][][]byte
+	for mask := 0; mask < 1<<len(shares); mask++ {
+		if bits.OnesCount(uint(mask)) != size {
+			continue
+		}
+		var subset [][]byte
+		for i, share := range shares {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, share)
+			}
+		}
+		result = append(result, subset)
+	}
+	return result
+}
+
+func TestShamirSubsets(t *testing.T) {
+	cases := []struct {
+		size, n, threshold int
+	}{
+		{1, 2, 2},
+		{1, 3, 2},
+		{1, 5, 3},
+		{32, 2, 2},
+		{32, 3, 2},
+		{32, 5, 3},
+		{32, 5, 5},
+		{32, 6, 4},
+	}
+	for _, c := range cases {
+		secret := make([]byte, c.size)
+		if _, err := rand.Read(secret); err != nil {
+			t.Fatal(err)
+		}
+		shares, err := SplitSecret(secret, c.n, c.threshold)
+		if err != nil {
+			t.Fatalf("SplitSecret(%d bytes, %d, %d): %v", c.size, c.n, c.threshold, err)
+		}
+
+		// any threshold shares or more recover the secret, in any order
+		for size := c.threshold; size <= c.n; size++ {
+			for _, subset := range subsets(shares, size) {
+				for _, order := range [][][]byte{subset, reversed(subset)} {
+					got, err := CombineShares(order)
+					if err != nil {
+						t.Errorf("%d of %d, %d bytes: CombineShares(%x): %v", size, c.n, c.size, xs(order), err)
+					} else if !bytes.Equal(got, secret) {
+						t.Errorf("%d of %d, %d bytes: CombineShares(%x) = %x, want %x", size, c.n, c.size, xs(order), got, secret)
+					}
+				}
+			}
+		}
+
+		// one share short leaves the secret open. A single byte still comes
+		// out right by chance one time in 256, so only the long secret is
+		// checked.
+		if c.size < 32 {
+			continue
+		}
+		for _, subset := range subsets(shares, c.threshold-1) {
+			got, err := CombineShares(subset)
+			if err == nil && bytes.Equal(got, secret) {
+				t.Errorf("%d of %d: CombineShares(%x) recovered the secret with %d shares", c.threshold, c.n, xs(subset), len(subset))
+			}
+		}
+	}
+}
+
+func TestCombineSharesRejects(t *testing.T) {
+	secret := []byte("correct horse battery staple....")
+	shares, err := SplitSecret(secret, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero := append([]byte{0}, shares[0][1:]...)
+
+	rejected := map[string][][]byte{
+		"no shares":          nil,
+		"one share":          {shares[0]},
+		"duplicate share":    {shares[0], shares[0]},
+		"duplicate x":        {shares[0], append([]byte{shares[0][0]}, shares[1][1:]...)},
+		"zero x":             {zero, shares[1]},
+		"different lengths":  {shares[0], shares[1][:len(shares[1])-1]},
+		"no value":           {shares[0][:1], shares[1][:1]},
+		"duplicate of three": {shares[0], shares[1], shares[0]},
+	}
+	for name, subset := range rejected {
+		if got, err := CombineShares(subset); err == nil {
+			t.Errorf("%s: CombineShares = %x, want an error", name, got)
+		}
+	}
+}
+
+func TestSplitSecretRejects(t *testing.T) {
+	for _, c := range []struct{ n, threshold int }{
+		{3, 1},
+		{3, 0},
+		{2, 3},
+		{256, 2},
+	} {
+		if _, err := SplitSecret([]byte("secret"), c.n, c.threshold); err == nil {
+			t.Errorf("SplitSecret(%d, %d): want an error", c.n, c.threshold)
+		}
+	}
+}
+
+func TestShareEncoding(t *testing.T) {
+	share := Share{Set: []byte{1, 2, 3, 4}, Threshold: 3, Data: []byte{7, 0xde, 0xad, 0xbe, 0xef}}
+	text := EncodeShare(share)
+	for _, typed := range []string{text, " " + strings.ToLower(text) + " "} {
+		got, err := DecodeShare(typed)
+		if err != nil {
+			t.Fatalf("DecodeShare(%q): %v", typed, err)
+		}
+		if !bytes.Equal(got.Set, share.Set) || got.Threshold != share.Threshold || !bytes.Equal(got.Data, share.Data) {
+			t.Errorf("DecodeShare(%q) = %+v, want %+v", typed, got, share)
+		}
+	}
+
+	typo := []byte(text)
+	if typo[len(typo)-1] == 'A' {
+		typo[len(typo)-1] = 'B'
+	} else {
+		typo[len(typo)-1] = 'A'
+	}
+	for _, bad := range []string{string(typo), "SPS-AAAA", "XYZ-" + text[4:], ""} {
+		if _, err := DecodeShare(bad); err == nil {
+			t.Errorf("DecodeShare(%q): want an error", bad)
+		}
+	}
+}
+
+func reversed(shares [][]byte) [][]byte {
+	result := make([][]byte, len(shares))
+	for i, share := range shares {
+		result[len(shares)-1-i] = share
+	}
+	return result
+}
+
+// xs lists the x coordinates of shares, for messages.
+func xs(shares [][]byte) []byte {
+	result := make([]byte, len(shares))
+	for i, share := range shares {
+		result[i] = share[0]
+	}
+	return result
+}
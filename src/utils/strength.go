@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+var strengthLabels = []string{"very weak", "weak", "fair", "strong", "very strong"}
+
+// StrengthReport is a zxcvbn estimate of how guessable a password is.
+type StrengthReport struct {
+	Score     int // 0 (very weak) to 4 (very strong)
+	Label     string
+	CrackTime string
+	Findings  []string
+}
+
+// EstimateStrength scores a password with zxcvbn, which looks for dictionary
+// words, keyboard walks, dates, repeats and sequences. userInputs (such as the
+// keystore name) are treated as extra dictionary words.
+func EstimateStrength(password string, userInputs []string) StrengthReport {
+	result := zxcvbn.PasswordStrength(password, userInputs)
+
+	report := StrengthReport{
+		Score:     result.Score,
+		Label:     strengthLabels[result.Score],
+		CrackTime: result.CrackTimeDisplay,
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range result.MatchSequence {
+		// single characters and pairs are noise, e.g. a trailing "1"
+		if len(m.Token) < 3 {
+			continue
+		}
+
+		var finding string
+		switch m.Pattern {
+		case "dictionary":
+			finding = fmt.Sprintf("dictionary word %q", m.Token)
+		case "spatial":
+			finding = fmt.Sprintf("keyboard pattern %q", m.Token)
+		case "date":
+			finding = fmt.Sprintf("date %q", m.Token)
+		case "repeat":
+			finding = fmt.Sprintf("repeated characters %q", m.Token)
+		case "sequence":
+			finding = fmt.Sprintf("sequence %q", m.Token)
+		default:
+			continue
+		}
+		if !seen[finding] {
+			seen[finding] = true
+			report.Findings = append(report.Findings, finding)
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Templates refer to entries either as {{ snowpass "keystore" "identifier" }},
+// with Go string quoting, or as sp://keystore/identifier, where characters
+// other than letters, digits and "._~-/@+" are percent-encoded. Everything
+// else in a template is copied as it is, other {{ }} included.
+var (
+	templateCallPattern  = regexp.MustCompile(`\{\{-?\s*snowpass\s+("(?:[^"\\\n]|\\.)*")\s+("(?:[^"\\\n]|\\.)*")\s*-?\}\}`)
+	templateStartPattern = regexp.MustCompile(`\{\{-?\s*snowpass\b`)
+	templateURIPattern   = regexp.MustCompile(`sp://([\p{L}\p{N}._~%-]+)/([\p{L}\p{N}._~%/@+-]+)`)
+)
+
+// TemplateRef is a reference to an entry found in a template. Start and End
+// are byte offsets of the whole reference, Line counts from 1.
+type TemplateRef struct {
+	Keystore   string
+	Identifier string
+	Line       int
+	Start, End int
+}
+
+// TemplateError is a reference that could not be read.
+type TemplateError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e TemplateError) Error() string {
+	return fmt.Sprintf("line %d: %s: %v", e.Line, e.Text, e.Err)
+}
+
+// FindTemplateRefs returns the references of a template in order, and an
+// error for every reference that is malformed.
+func FindTemplateRefs(template string) ([]TemplateRef, []TemplateError) {
+	var refs []TemplateRef
+	var problems []TemplateError
+	lineAt := func(offset int) int {
+		return strings.Count(template[:offset], "\n") + 1
+	}
+
+	calls := templateCallPattern.FindAllStringSubmatchIndex(template, -1)
+	isCall := make(map[int]bool, len(calls))
+	inCall := func(offset int) bool {
+		for _, m := range calls {
+			if offset >= m[0] && offset < m[1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range calls {
+		isCall[m[0]] = true
+		ref := TemplateRef{Line: lineAt(m[0]), Start: m[0], End: m[1]}
+		var err error
+		if ref.Keystore, err = strconv.Unquote(template[m[2]:m[3]]); err == nil {
+			ref.Identifier, err = strconv.Unquote(template[m[4]:m[5]])
+		}
+		if err != nil {
+			problems = append(problems, TemplateError{ref.Line, template[m[0]:m[1]], err})
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	for _, m := range templateStartPattern.FindAllStringIndex(template, -1) {
+		if !isCall[m[0]] {
+			end := strings.IndexAny(template[m[0]:], "\n")
+			if end < 0 {
+				end = len(template) - m[0]
+			}
+			problems = append(problems, TemplateError{lineAt(m[0]), template[m[0] : m[0]+end], fmt.Errorf(`expected {{ snowpass "keystore" "identifier" }}`)})
+		}
+	}
+
+	for _, m := range templateURIPattern.FindAllStringSubmatchIndex(template, -1) {
+		if inCall(m[0]) {
+			// part of the string of a {{ snowpass }} call
+			continue
+		}
+		ref := TemplateRef{Line: lineAt(m[0]), Start: m[0], End: m[1]}
+		var err error
+		if ref.Keystore, err = url.PathUnescape(template[m[2]:m[3]]); err == nil {
+			ref.Identifier, err = url.PathUnescape(template[m[4]:m[5]])
+		}
+		if err != nil {
+			problems = append(problems, TemplateError{ref.Line, template[m[0]:m[1]], err})
+			continue
+		}
+		refs = append(refs, ref)
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Start < refs[j].Start })
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return refs, problems
+}
+
+// RenderTemplate replaces the references of a template, as returned by
+// FindTemplateRefs, with their values. The result is sized up front, so no
+// copies of the values are left behind, and the caller destroys it.
+func RenderTemplate(template string, refs []TemplateRef, value func(TemplateRef) []byte) *SecureBuffer {
+	size := len(template)
+	for _, ref := range refs {
+		size += len(value(ref)) - (ref.End - ref.Start)
+	}
+	out := NewSecureBuffer(size)
+	n, last := 0, 0
+	for _, ref := range refs {
+		n += copy(out.Bytes()[n:], template[last:ref.Start])
+		n += copy(out.Bytes()[n:], value(ref))
+		last = ref.End
+	}
+	copy(out.Bytes()[n:], template[last:])
+	return out
+}